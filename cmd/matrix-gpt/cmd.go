@@ -1,36 +1,260 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
 	"github.com/mazzz1y/matrix-gpt/internal/bot"
 	"github.com/mazzz1y/matrix-gpt/internal/gpt"
+	"github.com/rs/zerolog/log"
 	"github.com/urfave/cli/v2"
 )
 
 func run(c *cli.Context) error {
+	if err := requireFlags(c, "openai-token", "user-ids"); err != nil {
+		return err
+	}
+
 	mPassword := c.String("matrix-password")
+	mAccessToken := c.String("matrix-access-token")
+	mDeviceId := c.String("matrix-device-id")
 	mUserId := c.String("matrix-id")
 	mUrl := c.String("matrix-url")
 	sqlitePath := c.String("sqlite-path")
+	pickleKey := c.String("pickle-key")
+	pickleKeyFile := c.String("pickle-key-file")
+	allowLegacyPickleKey := c.Bool("allow-legacy-pickle-key")
+	recoveryKey := c.String("recovery-key")
+	resetCrossSigning := c.Bool("reset-cross-signing")
+	autoConfirmSAS := c.Bool("auto-confirm-sas")
+	keyBackup := c.Bool("key-backup")
+	encryptionPolicy := c.String("encryption-policy")
 
 	gptModel := c.String("gpt-model")
 	gptTimeout := c.Int("gpt-timeout")
 	openaiToken := c.String("openai-token")
 	maxAttempts := c.Int("max-attempts")
+	kbTopK := c.Int("kb-top-k")
+	kbMinScore := c.Float64("kb-min-score")
+	memoryTool := c.Bool("memory-tool")
+	personasFile := c.String("personas-file")
+	customPersonas := c.Bool("custom-personas")
+	allowedModels := c.StringSlice("allowed-models")
+	schemasFile := c.String("schemas-file")
+
+	moderation := c.String("moderation")
+	moderationThresholds := c.StringSlice("moderation-thresholds")
+	moderationAnswers := c.Bool("moderation-answers")
+	moderationImages := c.Bool("moderation-images")
+
+	redact := c.Bool("redact")
+	redactDetectors := c.StringSlice("redact-detectors")
+	redactDomains := c.StringSlice("redact-domains")
+	redactPatterns := c.StringSlice("redact-patterns")
+	redactKeywords := c.StringSlice("redact-keywords")
 
 	historyExpire := c.Int("history-expire")
 	historyLimit := c.Int("history-limit")
+	answerRetention := c.Int("answer-retention")
 	userIDs := c.StringSlice("user-ids")
+	adminIDs := c.StringSlice("admin-ids")
+
+	inviteDMOnly := c.Bool("invite-dm-only")
+	inviteMaxMembers := c.Int("invite-max-members")
+	inviteServers := c.StringSlice("invite-servers")
+
+	userConcurrency := c.Int("user-concurrency")
+	maxConcurrency := c.Int("max-concurrency")
 
 	logLevel := c.String("log-level")
 	logType := c.String("log-type")
 
+	httpAddr := c.String("http-addr")
+	apiTokens := c.StringSlice("api-tokens")
+	apiRateLimit := c.Int("api-rate-limit")
+	asRegistration := c.String("appservice-registration")
+	asListen := c.String("appservice-listen")
+	shutdownTimeout := time.Duration(c.Int("shutdown-timeout")) * time.Second
+
 	setLogLevel(logLevel, logType)
 
 	g := gpt.New(openaiToken, gptModel, historyLimit, gptTimeout, maxAttempts)
-	m, err := bot.NewBot(mUrl, mUserId, mPassword, sqlitePath, historyExpire, historyLimit, userIDs, g)
+	m, err := bot.NewBot(bot.Config{
+		ServerURL:        mUrl,
+		UserID:           mUserId,
+		AccessToken:      mAccessToken,
+		DeviceID:         mDeviceId,
+		Password:         mPassword,
+		SQLitePath:       sqlitePath,
+		PickleKey:        pickleKey,
+		PickleKeyFile:    pickleKeyFile,
+		RecoveryKey:      recoveryKey,
+		AutoConfirmSAS:   autoConfirmSAS,
+		KeyBackup:        keyBackup,
+		EncryptionPolicy: encryptionPolicy,
+		HistoryExpire:    historyExpire,
+		HistoryLimit:     historyLimit,
+		AnswerRetention:  answerRetention,
+		UserIDs:          userIDs,
+		AdminIDs:         adminIDs,
+		UserConcurrency:  userConcurrency,
+		MaxConcurrency:   maxConcurrency,
+		InviteDMOnly:     inviteDMOnly,
+		InviteMaxMembers: inviteMaxMembers,
+		InviteServers:    inviteServers,
+		APITokens:        apiTokens,
+		APIRateLimit:     apiRateLimit,
+		KBTopK:           kbTopK,
+		KBMinScore:       kbMinScore,
+		MemoryTool:       memoryTool,
+		PersonasFile:     personasFile,
+		CustomPersonas:   customPersonas,
+		AllowedModels:    allowedModels,
+		SchemasFile:      schemasFile,
+
+		Moderation:           moderation,
+		ModerationThresholds: moderationThresholds,
+		ModerationAnswers:    moderationAnswers,
+		ModerationImages:     moderationImages,
+
+		Redact:          redact,
+		RedactDetectors: redactDetectors,
+		RedactDomains:   redactDomains,
+		RedactPatterns:  redactPatterns,
+		RedactKeywords:  redactKeywords,
+
+		AllowLegacyPickleKey:   allowLegacyPickleKey,
+		ResetCrossSigning:      resetCrossSigning,
+		AppserviceRegistration: asRegistration,
+		AppserviceListen:       asListen,
+	}, g)
 	if err != nil {
 		return err
 	}
 
-	return m.StartHandler()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var srv *http.Server
+	if httpAddr != "" {
+		srv = &http.Server{Addr: httpAddr, Handler: m.HTTPHandler()}
+		go func() {
+			log.Info().Str("http-addr", httpAddr).Msg("starting http server")
+			if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Err(err).Msg("http server error")
+			}
+		}()
+	}
+
+	syncErr := m.StartHandler(ctx)
+	log.Info().Msg("shutting down")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err := m.Shutdown(shutdownCtx); err != nil {
+		log.Err(err).Msg("bot shutdown error")
+	}
+	if srv != nil {
+		_ = srv.Shutdown(shutdownCtx)
+	}
+
+	return syncErr
+}
+
+func migratePickleKey(c *cli.Context) error {
+	setLogLevel(c.String("log-level"), c.String("log-type"))
+
+	cfg := bot.Config{
+		SQLitePath:    c.String("sqlite-path"),
+		PickleKey:     c.String("pickle-key"),
+		PickleKeyFile: c.String("pickle-key-file"),
+	}
+
+	if err := bot.MigratePickleKey(c.Context, cfg, []byte(c.String("old-pickle-key"))); err != nil {
+		return err
+	}
+
+	log.Info().Msg("crypto store migrated to the new pickle key")
+	return nil
+}
+
+func ingest(c *cli.Context) error {
+	setLogLevel(c.String("log-level"), c.String("log-type"))
+
+	if err := requireFlags(c, "openai-token"); err != nil {
+		return err
+	}
+	if c.NArg() == 0 {
+		return errors.New("no files to ingest")
+	}
+
+	g := gpt.New(c.String("openai-token"), c.String("gpt-model"), c.Int("history-limit"), c.Int("gpt-timeout"), c.Int("max-attempts"))
+	return bot.IngestDocuments(c.Context, bot.Config{
+		SQLitePath:      c.String("sqlite-path"),
+		Redact:          c.Bool("redact"),
+		RedactDetectors: c.StringSlice("redact-detectors"),
+		RedactDomains:   c.StringSlice("redact-domains"),
+		RedactPatterns:  c.StringSlice("redact-patterns"),
+		RedactKeywords:  c.StringSlice("redact-keywords"),
+	}, g, c.Args().Slice())
+}
+
+func exportFeedback(c *cli.Context) error {
+	setLogLevel(c.String("log-level"), c.String("log-type"))
+
+	path := c.String("output")
+	var w io.Writer = os.Stdout
+	if path != "-" {
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	if err := bot.ExportFeedback(c.Context, bot.Config{SQLitePath: c.String("sqlite-path")}, w); err != nil {
+		return err
+	}
+
+	if path != "-" {
+		log.Info().Str("path", path).Msg("feedback exported")
+	}
+	return nil
+}
+
+func generateRegistration(c *cli.Context) error {
+	setLogLevel(c.String("log-level"), c.String("log-type"))
+
+	reg, err := bot.GenerateRegistration(c.String("appservice-id"), c.String("appservice-url"), c.String("matrix-id"))
+	if err != nil {
+		return err
+	}
+
+	path := c.String("output")
+	if err := reg.Save(path); err != nil {
+		return err
+	}
+
+	log.Info().Str("path", path).Msg("registration saved, add it to the homeserver config")
+	return nil
+}
+
+// requireFlags returns an error if any of the given flags is not set.
+// It is used for flags that are required by the bot but not by the subcommands.
+func requireFlags(c *cli.Context, names ...string) error {
+	for _, name := range names {
+		if !c.IsSet(name) {
+			return fmt.Errorf("required flag %q not set", name)
+		}
+	}
+	return nil
 }
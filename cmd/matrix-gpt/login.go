@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/mazzz1y/matrix-gpt/internal/bot"
+	"github.com/rs/zerolog/log"
+	"github.com/urfave/cli/v2"
+	"maunium.net/go/mautrix"
+)
+
+func login(c *cli.Context) error {
+	mPassword := c.String("matrix-password")
+	mUserId := c.String("matrix-id")
+	mUrl := c.String("matrix-url")
+	sqlitePath := c.String("sqlite-path")
+
+	loginToken := c.String("login-token")
+	sso := c.Bool("sso")
+	ssoListen := c.String("sso-listen")
+
+	setLogLevel(c.String("log-level"), c.String("log-type"))
+
+	req := &mautrix.ReqLogin{
+		Identifier:               mautrix.UserIdentifier{Type: mautrix.IdentifierTypeUser, User: mUserId},
+		InitialDeviceDisplayName: c.App.Name,
+	}
+
+	switch {
+	case sso:
+		token, err := ssoLoginToken(c.Context, mUrl, ssoListen)
+		if err != nil {
+			return err
+		}
+		req.Type = mautrix.AuthTypeToken
+		req.Token = token
+	case loginToken != "":
+		req.Type = mautrix.AuthTypeToken
+		req.Token = loginToken
+	case mPassword != "":
+		req.Type = mautrix.AuthTypePassword
+		req.Password = mPassword
+	default:
+		return errors.New("either --sso, --login-token or --matrix-password is required")
+	}
+
+	sess, err := bot.Login(c.Context, mUrl, sqlitePath, req)
+	if err != nil {
+		return err
+	}
+
+	log.Info().
+		Str("user-id", sess.UserID).
+		Str("device-id", sess.DeviceID).
+		Msg("session saved, the bot can now be started without a password")
+
+	return nil
+}
+
+// ssoLoginToken prints the SSO login URL and waits for the homeserver to redirect
+// the browser back to a local listener with a login token.
+func ssoLoginToken(ctx context.Context, serverUrl, listenAddr string) (string, error) {
+	client, err := mautrix.NewClient(serverUrl, "", "")
+	if err != nil {
+		return "", err
+	}
+
+	ssoUrl := client.BuildURLWithQuery(
+		mautrix.ClientURLPath{"v3", "login", "sso", "redirect"},
+		map[string]string{"redirectUrl": "http://" + listenAddr + "/"},
+	)
+	fmt.Printf("Open the following URL in a browser to log in:\n\n%s\n\n", ssoUrl)
+
+	tokens := make(chan string, 1)
+	errs := make(chan error, 1)
+
+	srv := &http.Server{
+		Addr: listenAddr,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token := r.URL.Query().Get("loginToken")
+			if token == "" {
+				http.Error(w, "missing login token", http.StatusBadRequest)
+				return
+			}
+
+			_, _ = fmt.Fprintln(w, "Login successful, you can close this page.")
+			select {
+			case tokens <- token:
+			default:
+			}
+		}),
+	}
+	defer srv.Close()
+
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			errs <- err
+		}
+	}()
+
+	select {
+	case token := <-tokens:
+		return token, nil
+	case err := <-errs:
+		return "", err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
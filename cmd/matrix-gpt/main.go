@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 
+	"github.com/mazzz1y/matrix-gpt/internal/bot"
 	"github.com/sashabaranov/go-openai"
 	"github.com/urfave/cli/v2"
 )
@@ -16,12 +17,96 @@ func main() {
 		Version: version,
 		Usage:   "GPT Matrix Bot",
 		Action:  run,
+		Commands: []*cli.Command{
+			{
+				Name:   "login",
+				Usage:  "Log in once with a password or SSO and store the session in the SQLite database",
+				Action: login,
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "sso",
+						Usage: "Log in via SSO in a browser",
+					},
+					&cli.StringFlag{
+						Name:  "sso-listen",
+						Usage: "Local address to receive the SSO redirect on",
+						Value: "127.0.0.1:8765",
+					},
+					&cli.StringFlag{
+						Name:  "login-token",
+						Usage: "Log in with an existing m.login.token",
+					},
+				},
+			},
+			{
+				Name:   "migrate-pickle-key",
+				Usage:  "Re-encrypt the crypto store from an old pickle key to the configured one",
+				Action: migratePickleKey,
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:    "old-pickle-key",
+						Usage:   "Pickle key the crypto store is currently encrypted with",
+						EnvVars: []string{"OLD_PICKLE_KEY"},
+						Value:   bot.LegacyPickleKey,
+					},
+				},
+			},
+			{
+				Name:   "generate-registration",
+				Usage:  "Generate an appservice registration file for the homeserver",
+				Action: generateRegistration,
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:     "appservice-url",
+						Usage:    "URL the homeserver reaches the appservice at (e.g. http://matrix-gpt:29330)",
+						Required: true,
+					},
+					&cli.StringFlag{
+						Name:  "appservice-id",
+						Usage: "Unique ID of the appservice",
+						Value: "matrix-gpt",
+					},
+					&cli.StringFlag{
+						Name:  "output",
+						Usage: "Path to write the registration to",
+						Value: "registration.yaml",
+					},
+				},
+			},
+			{
+				Name:      "ingest",
+				Usage:     "Add text files to the knowledge base, replacing previously ingested files with the same name",
+				ArgsUsage: "<file>...",
+				Action:    ingest,
+			},
+			{
+				Name:   "export-feedback",
+				Usage:  "Write the recorded answer ratings as JSONL",
+				Action: exportFeedback,
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "output",
+						Usage: "Path to write the feedback to, - for stdout",
+						Value: "feedback.jsonl",
+					},
+				},
+			},
+		},
 		Flags: []cli.Flag{
 			&cli.StringFlag{
-				Name:     "matrix-password",
-				Usage:    "Matrix password",
-				EnvVars:  []string{"MATRIX_PASSWORD"},
-				Required: true,
+				Name:    "matrix-password",
+				Usage:   "Matrix password, only needed if there is neither an access token nor a stored session",
+				EnvVars: []string{"MATRIX_PASSWORD"},
+			},
+			&cli.StringFlag{
+				Name:    "matrix-access-token",
+				Usage:   "Matrix access token, used instead of a stored session or a password",
+				EnvVars: []string{"MATRIX_ACCESS_TOKEN"},
+			},
+			&cli.StringFlag{
+				Name:    "matrix-device-id",
+				Usage:   "Matrix device ID of the access token, looked up on the server if empty",
+				EnvVars: []string{"MATRIX_DEVICE_ID"},
 			},
 			&cli.StringFlag{
 				Name:     "matrix-id",
@@ -36,10 +121,50 @@ func main() {
 				Required: true,
 			},
 			&cli.StringFlag{
-				Name:     "openai-token",
-				Usage:    "OpenAI API token",
-				EnvVars:  []string{"OPENAI_TOKEN"},
-				Required: true,
+				Name:    "pickle-key",
+				Usage:   "Key encrypting the Olm/Megolm sessions in the crypto store",
+				EnvVars: []string{"PICKLE_KEY"},
+			},
+			&cli.StringFlag{
+				Name:    "pickle-key-file",
+				Usage:   "Path to a file containing the pickle key, generated next to the database if neither is set",
+				EnvVars: []string{"PICKLE_KEY_FILE"},
+			},
+			&cli.BoolFlag{
+				Name:    "allow-legacy-pickle-key",
+				Usage:   "Keep using the hardcoded pickle key of previous versions for existing crypto stores",
+				EnvVars: []string{"ALLOW_LEGACY_PICKLE_KEY"},
+			},
+			&cli.StringFlag{
+				Name:    "recovery-key",
+				Usage:   "Recovery key of the secret storage, used to cross-sign the bot device",
+				EnvVars: []string{"RECOVERY_KEY"},
+			},
+			&cli.BoolFlag{
+				Name:    "reset-cross-signing",
+				Usage:   "Generate new cross-signing keys even if the account already has some, remove after use",
+				EnvVars: []string{"RESET_CROSS_SIGNING"},
+			},
+			&cli.BoolFlag{
+				Name:    "auto-confirm-sas",
+				Usage:   "Accept emoji and number verifications from allowed users without comparing them",
+				EnvVars: []string{"AUTO_CONFIRM_SAS"},
+			},
+			&cli.StringFlag{
+				Name:    "encryption-policy",
+				Usage:   "Whether the bot responds in unencrypted rooms (allow) or only in encrypted ones (require)",
+				EnvVars: []string{"ENCRYPTION_POLICY"},
+				Value:   bot.EncryptionPolicyAllow,
+			},
+			&cli.BoolFlag{
+				Name:    "key-backup",
+				Usage:   "Upload Megolm sessions to the server-side key backup and restore them on startup",
+				EnvVars: []string{"KEY_BACKUP"},
+			},
+			&cli.StringFlag{
+				Name:    "openai-token",
+				Usage:   "OpenAI API token (required)",
+				EnvVars: []string{"OPENAI_TOKEN"},
 			},
 			&cli.StringFlag{
 				Name:     "sqlite-path",
@@ -59,6 +184,12 @@ func main() {
 				EnvVars: []string{"HISTORY_EXPIRE"},
 				Value:   3,
 			},
+			&cli.IntFlag{
+				Name:    "answer-retention",
+				Usage:   "Number of days sent answers are kept to be rated, 0 to keep them forever",
+				EnvVars: []string{"ANSWER_RETENTION"},
+				Value:   30,
+			},
 			&cli.StringFlag{
 				Name:    "gpt-model",
 				Usage:   "GPT model name/version",
@@ -77,11 +208,157 @@ func main() {
 				EnvVars: []string{"MAX_ATTEMPTS"},
 				Value:   3,
 			},
+			&cli.IntFlag{
+				Name:    "kb-top-k",
+				Usage:   "Number of knowledge base excerpts added to completions (0 to disable)",
+				EnvVars: []string{"KB_TOP_K"},
+				Value:   3,
+			},
+			&cli.Float64Flag{
+				Name:    "kb-min-score",
+				Usage:   "Minimum similarity (0-1) of a knowledge base excerpt to the message to be added",
+				EnvVars: []string{"KB_MIN_SCORE"},
+				Value:   0.78,
+			},
+			&cli.BoolFlag{
+				Name:    "memory-tool",
+				Usage:   "Let the model save facts about users on its own, in addition to the !remember command",
+				EnvVars: []string{"MEMORY_TOOL"},
+			},
+			&cli.StringFlag{
+				Name:    "personas-file",
+				Usage:   "Path to a YAML file with the default generation parameters and named personas",
+				EnvVars: []string{"PERSONAS_FILE"},
+			},
+			&cli.BoolFlag{
+				Name:    "custom-personas",
+				Usage:   "Let rooms set a free-text system prompt as persona, not only the names of the personas",
+				EnvVars: []string{"CUSTOM_PERSONAS"},
+			},
 			&cli.StringSliceFlag{
-				Name:     "user-ids",
-				Usage:    "List of allowed Matrix user IDs",
-				EnvVars:  []string{"USER_IDS"},
-				Required: true,
+				Name:    "allowed-models",
+				Usage:   "Models rooms may select, the default model and the models of the personas if empty",
+				EnvVars: []string{"ALLOWED_MODELS"},
+			},
+			&cli.StringFlag{
+				Name:    "schemas-file",
+				Usage:   "Path to a YAML file with the named JSON schemas for the !json command",
+				EnvVars: []string{"SCHEMAS_FILE"},
+			},
+			&cli.StringFlag{
+				Name:    "moderation",
+				Usage:   "Check prompts with the moderation endpoint and flag or block matching ones (flag, block), disabled if empty",
+				EnvVars: []string{"MODERATION"},
+			},
+			&cli.StringSliceFlag{
+				Name:    "moderation-thresholds",
+				Usage:   "Minimum moderation category scores, e.g. violence=0.7, other categories match if the endpoint flags them",
+				EnvVars: []string{"MODERATION_THRESHOLDS"},
+			},
+			&cli.BoolFlag{
+				Name:    "moderation-answers",
+				Usage:   "Also moderate answers",
+				EnvVars: []string{"MODERATION_ANSWERS"},
+			},
+			&cli.BoolFlag{
+				Name:    "moderation-images",
+				Usage:   "Also moderate image prompts",
+				EnvVars: []string{"MODERATION_IMAGES"},
+			},
+			&cli.BoolFlag{
+				Name:    "redact",
+				Usage:   "Replace personal data and secrets in messages with placeholders before sending them to OpenAI",
+				EnvVars: []string{"REDACT"},
+			},
+			&cli.StringSliceFlag{
+				Name:    "redact-detectors",
+				Usage:   "Enabled built-in detectors (email, api_key, iban, phone, hostname), all if empty",
+				EnvVars: []string{"REDACT_DETECTORS"},
+			},
+			&cli.StringSliceFlag{
+				Name:    "redact-domains",
+				Usage:   "Domain suffixes of internal hostnames, e.g. corp.example.com, common internal suffixes if empty",
+				EnvVars: []string{"REDACT_DOMAINS"},
+			},
+			&cli.StringSliceFlag{
+				Name:    "redact-patterns",
+				Usage:   "Custom redaction rules in the form NAME=regexp",
+				EnvVars: []string{"REDACT_PATTERNS"},
+			},
+			&cli.StringSliceFlag{
+				Name:    "redact-keywords",
+				Usage:   "Keywords to redact, case-insensitive",
+				EnvVars: []string{"REDACT_KEYWORDS"},
+			},
+			&cli.StringSliceFlag{
+				Name:    "user-ids",
+				Usage:   "List of allowed Matrix user IDs (required)",
+				EnvVars: []string{"USER_IDS"},
+			},
+			&cli.StringSliceFlag{
+				Name:    "admin-ids",
+				Usage:   "List of allowed Matrix user IDs that may use admin commands",
+				EnvVars: []string{"ADMIN_IDS"},
+			},
+			&cli.BoolFlag{
+				Name:    "invite-dm-only",
+				Usage:   "Only accept invites to direct messages",
+				EnvVars: []string{"INVITE_DM_ONLY"},
+			},
+			&cli.IntFlag{
+				Name:    "invite-max-members",
+				Usage:   "Maximum number of members of rooms the bot stays in (0 for unlimited)",
+				EnvVars: []string{"INVITE_MAX_MEMBERS"},
+			},
+			&cli.StringSliceFlag{
+				Name:    "invite-servers",
+				Usage:   "List of homeservers whose users the bot accepts invites from, all if empty",
+				EnvVars: []string{"INVITE_SERVERS"},
+			},
+			&cli.StringFlag{
+				Name:    "http-addr",
+				Usage:   "Address for the HTTP server with /healthz and /readyz endpoints (e.g. :8080), disabled if empty",
+				EnvVars: []string{"HTTP_ADDR"},
+			},
+			&cli.StringSliceFlag{
+				Name:    "api-tokens",
+				Usage:   "List of bearer tokens for the HTTP API on http-addr, disabled if empty",
+				EnvVars: []string{"API_TOKENS"},
+			},
+			&cli.IntFlag{
+				Name:    "api-rate-limit",
+				Usage:   "Maximum number of HTTP API requests per token and minute (0 for unlimited)",
+				EnvVars: []string{"API_RATE_LIMIT"},
+				Value:   30,
+			},
+			&cli.IntFlag{
+				Name:    "user-concurrency",
+				Usage:   "Maximum number of requests processed in parallel per user (0 for unlimited)",
+				EnvVars: []string{"USER_CONCURRENCY"},
+				Value:   1,
+			},
+			&cli.IntFlag{
+				Name:    "max-concurrency",
+				Usage:   "Maximum number of requests processed in parallel across all users (0 for unlimited)",
+				EnvVars: []string{"MAX_CONCURRENCY"},
+				Value:   10,
+			},
+			&cli.IntFlag{
+				Name:    "shutdown-timeout",
+				Usage:   "Time to wait for in-flight requests on shutdown before cancelling them (in seconds)",
+				EnvVars: []string{"SHUTDOWN_TIMEOUT"},
+				Value:   30,
+			},
+			&cli.StringFlag{
+				Name:    "appservice-registration",
+				Usage:   "Path to the appservice registration file, enables appservice mode instead of syncing",
+				EnvVars: []string{"APPSERVICE_REGISTRATION"},
+			},
+			&cli.StringFlag{
+				Name:    "appservice-listen",
+				Usage:   "Address for the appservice HTTP server the homeserver sends events to",
+				EnvVars: []string{"APPSERVICE_LISTEN"},
+				Value:   ":29330",
 			},
 			&cli.StringFlag{
 				Name:    "log-level",
@@ -0,0 +1,105 @@
+package backup
+
+import (
+	"errors"
+	"net/http"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// Algorithm is the only supported server-side key backup algorithm.
+const Algorithm = "m.megolm_backup.v1.curve25519-aes-sha2"
+
+// SecretType is the secret storage account data type holding the backup key.
+var SecretType = event.Type{Type: "m.megolm_backup.v1", Class: event.AccountDataEventType}
+
+// AuthData holds the public key of a backup version.
+type AuthData struct {
+	PublicKey  string             `json:"public_key"`
+	Signatures mautrix.Signatures `json:"signatures,omitempty"`
+}
+
+// Version describes a backup version on the server.
+type Version struct {
+	Algorithm string   `json:"algorithm"`
+	AuthData  AuthData `json:"auth_data"`
+	Count     int      `json:"count,omitempty"`
+	ETag      string   `json:"etag,omitempty"`
+	Version   string   `json:"version,omitempty"`
+}
+
+// SessionData is an encrypted backed up session.
+type SessionData struct {
+	Ephemeral  string `json:"ephemeral"`
+	Ciphertext string `json:"ciphertext"`
+	MAC        string `json:"mac"`
+}
+
+// KeyBackupData is a backed up session with its metadata.
+type KeyBackupData struct {
+	FirstMessageIndex uint32       `json:"first_message_index"`
+	ForwardedCount    int          `json:"forwarded_count"`
+	IsVerified        bool         `json:"is_verified"`
+	SessionData       *SessionData `json:"session_data"`
+}
+
+// RoomKeys holds the backed up sessions of a room.
+type RoomKeys struct {
+	Sessions map[id.SessionID]KeyBackupData `json:"sessions"`
+}
+
+// Keys holds backed up sessions by room.
+type Keys struct {
+	Rooms map[id.RoomID]RoomKeys `json:"rooms"`
+}
+
+// SessionKey is the decrypted content of a backed up session.
+type SessionKey struct {
+	Algorithm         id.Algorithm      `json:"algorithm"`
+	ForwardingChains  []string          `json:"forwarding_curve25519_key_chain"`
+	SenderKey         id.SenderKey      `json:"sender_key"`
+	SenderClaimedKeys map[string]string `json:"sender_claimed_keys"`
+	SessionKey        string            `json:"session_key"`
+}
+
+// GetLatestVersion returns the current backup version, or nil if there is no backup.
+func GetLatestVersion(client *mautrix.Client) (*Version, error) {
+	var v Version
+	_, err := client.MakeRequest(http.MethodGet, client.BuildClientURL("v3", "room_keys", "version"), nil, &v)
+	if errors.Is(err, mautrix.MNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+// CreateVersion creates a new backup version and returns its identifier.
+func CreateVersion(client *mautrix.Client, authData AuthData) (string, error) {
+	var resp struct {
+		Version string `json:"version"`
+	}
+	req := Version{Algorithm: Algorithm, AuthData: authData}
+	_, err := client.MakeRequest(http.MethodPost, client.BuildClientURL("v3", "room_keys", "version"), &req, &resp)
+	return resp.Version, err
+}
+
+// PutKeys uploads sessions to the given backup version.
+func PutKeys(client *mautrix.Client, version string, keys *Keys) error {
+	_, err := client.MakeRequest(http.MethodPut, keysURL(client, version), keys, nil)
+	return err
+}
+
+// GetKeys downloads all sessions of the given backup version.
+func GetKeys(client *mautrix.Client, version string) (*Keys, error) {
+	var keys Keys
+	_, err := client.MakeRequest(http.MethodGet, keysURL(client, version), nil, &keys)
+	return &keys, err
+}
+
+func keysURL(client *mautrix.Client, version string) string {
+	return client.BuildURLWithQuery(mautrix.ClientURLPath{"v3", "room_keys", "keys"}, map[string]string{"version": version})
+}
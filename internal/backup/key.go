@@ -0,0 +1,176 @@
+package backup
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"io"
+	"strings"
+
+	"golang.org/x/crypto/hkdf"
+	"maunium.net/go/mautrix/crypto/utils"
+)
+
+var (
+	errInvalidRecoveryKey = errors.New("invalid backup recovery key")
+	errInvalidPadding     = errors.New("invalid padding")
+)
+
+// Key is the curve25519 key pair used to encrypt the backed up sessions.
+type Key struct {
+	priv *ecdh.PrivateKey
+}
+
+// NewKey generates a new random backup key.
+func NewKey() (*Key, error) {
+	priv, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return &Key{priv: priv}, nil
+}
+
+// KeyFromBytes creates a backup key from the raw private key.
+func KeyFromBytes(b []byte) (*Key, error) {
+	priv, err := ecdh.X25519().NewPrivateKey(b)
+	if err != nil {
+		return nil, err
+	}
+	return &Key{priv: priv}, nil
+}
+
+// KeyFromRecoveryKey creates a backup key from its base58 recovery key representation.
+func KeyFromRecoveryKey(recoveryKey string) (*Key, error) {
+	b := utils.DecodeBase58RecoveryKey(recoveryKey)
+	if b == nil {
+		return nil, errInvalidRecoveryKey
+	}
+	return KeyFromBytes(b)
+}
+
+// Bytes returns the raw private key.
+func (k *Key) Bytes() []byte {
+	return k.priv.Bytes()
+}
+
+// RecoveryKey returns the base58 recovery key representation of the private key.
+func (k *Key) RecoveryKey() string {
+	return utils.EncodeBase58RecoveryKey(k.priv.Bytes())
+}
+
+// PublicKey returns the unpadded base64 public key, as used in the backup auth data.
+func (k *Key) PublicKey() string {
+	return base64.RawStdEncoding.EncodeToString(k.priv.PublicKey().Bytes())
+}
+
+// Encrypt encrypts the session data as described for m.megolm_backup.v1.curve25519-aes-sha2.
+func (k *Key) Encrypt(plaintext []byte) (*SessionData, error) {
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	shared, err := ephemeral.ECDH(k.priv.PublicKey())
+	if err != nil {
+		return nil, err
+	}
+
+	aesKey, macKey, iv, err := deriveKeys(shared)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(aesKey)
+	if err != nil {
+		return nil, err
+	}
+
+	ciphertext := pkcs7Pad(plaintext, aes.BlockSize)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(ciphertext, ciphertext)
+
+	mac := hmac.New(sha256.New, macKey)
+	mac.Write(ciphertext)
+
+	return &SessionData{
+		Ephemeral:  base64.RawStdEncoding.EncodeToString(ephemeral.PublicKey().Bytes()),
+		Ciphertext: base64.RawStdEncoding.EncodeToString(ciphertext),
+		MAC:        base64.RawStdEncoding.EncodeToString(mac.Sum(nil)[:8]),
+	}, nil
+}
+
+// Decrypt decrypts the session data. The MAC is not verified, because some clients compute it incorrectly.
+func (k *Key) Decrypt(data *SessionData) ([]byte, error) {
+	ephemeralBytes, err := decodeBase64(data.Ephemeral)
+	if err != nil {
+		return nil, err
+	}
+
+	ephemeral, err := ecdh.X25519().NewPublicKey(ephemeralBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	shared, err := k.priv.ECDH(ephemeral)
+	if err != nil {
+		return nil, err
+	}
+
+	aesKey, _, iv, err := deriveKeys(shared)
+	if err != nil {
+		return nil, err
+	}
+
+	ciphertext, err := decodeBase64(data.Ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) == 0 || len(ciphertext)%aes.BlockSize != 0 {
+		return nil, errInvalidPadding
+	}
+
+	block, err := aes.NewCipher(aesKey)
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext := make([]byte, len(ciphertext))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plaintext, ciphertext)
+
+	return pkcs7Unpad(plaintext)
+}
+
+// deriveKeys derives the AES key, MAC key and IV from the shared secret using HKDF-SHA256.
+func deriveKeys(shared []byte) (aesKey, macKey, iv []byte, err error) {
+	out := make([]byte, 80)
+	r := hkdf.New(sha256.New, shared, make([]byte, 32), nil)
+	if _, err := io.ReadFull(r, out); err != nil {
+		return nil, nil, nil, err
+	}
+	return out[:32], out[32:64], out[64:], nil
+}
+
+// pkcs7Pad returns a copy of data padded to a multiple of the block size.
+func pkcs7Pad(data []byte, blockSize int) []byte {
+	n := blockSize - len(data)%blockSize
+	return append(bytes.Clone(data), bytes.Repeat([]byte{byte(n)}, n)...)
+}
+
+// pkcs7Unpad removes the padding added by pkcs7Pad.
+func pkcs7Unpad(data []byte) ([]byte, error) {
+	n := int(data[len(data)-1])
+	if n == 0 || n > len(data) || n > aes.BlockSize {
+		return nil, errInvalidPadding
+	}
+	return data[:len(data)-n], nil
+}
+
+// decodeBase64 decodes base64 with or without padding.
+func decodeBase64(s string) ([]byte, error) {
+	return base64.RawStdEncoding.DecodeString(strings.TrimRight(s, "="))
+}
@@ -0,0 +1,182 @@
+package backup
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+// The key pairs of RFC 7748, section 6.1. Alice's key is the backup key, Bob's key the ephemeral one.
+const (
+	alicePrivate = "77076d0a7318a57d3c16c17251b26645df4c2f87ebc0992ab177fba51db92c2a"
+	alicePublic  = "8520f0098930a754748b7ddcb43ef75a0dbf3a0d26381af4eba4a98eaa9b4e6a"
+	bobPublic    = "de9edb7d7b7dc1b4d35b61c2ece435373f8343c85b78674dadfc7e146f882b4f"
+)
+
+func mustHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	key, err := NewKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		plaintext []byte
+	}{
+		{"empty", nil},
+		{"short", []byte("{}")},
+		{"block size", bytes.Repeat([]byte("a"), 16)},
+		{"longer than a block", bytes.Repeat([]byte("a"), 17)},
+		{"session", []byte(`{"algorithm":"m.megolm.v1.aes-sha2","session_key":"AQAAAAA"}`)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := key.Encrypt(tt.plaintext)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := key.Decrypt(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, tt.plaintext) {
+				t.Errorf("Decrypt() = %q, want %q", got, tt.plaintext)
+			}
+		})
+	}
+}
+
+func TestDecryptWithOtherKey(t *testing.T) {
+	key, err := NewKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := NewKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := key.Encrypt([]byte("secret session"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, err := other.Decrypt(data); err == nil && string(got) == "secret session" {
+		t.Error("Decrypt() with another key returned the plaintext")
+	}
+}
+
+func TestDecryptKnownVector(t *testing.T) {
+	key, err := KeyFromBytes(mustHex(t, alicePrivate))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Encrypted with Bob's ephemeral key: the shared secret is the one of RFC 7748, the AES key and IV are derived
+	// with HKDF-SHA256, a salt of 32 zero bytes and empty info, as the spec describes.
+	data := &SessionData{
+		Ephemeral:  base64.RawStdEncoding.EncodeToString(mustHex(t, bobPublic)),
+		Ciphertext: "9lq9DgATQh0Ey5ZaVGHfoeMtfpavaYtV17dAmUZKJ5IHOCF7fvSQ8UcWQV28eOU9rrC7eNoz89XshJFHNFGqOw",
+	}
+
+	got, err := key.Decrypt(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"algorithm":"m.megolm.v1.aes-sha2","session_key":"AQAAAAA"}`; string(got) != want {
+		t.Errorf("Decrypt() = %q, want %q", got, want)
+	}
+
+	// Padded base64, as some clients send it, must be accepted too.
+	data.Ciphertext += "=="
+	if _, err := key.Decrypt(data); err != nil {
+		t.Errorf("Decrypt() with padded base64: %v", err)
+	}
+}
+
+func TestDecryptInvalid(t *testing.T) {
+	key, err := KeyFromBytes(mustHex(t, alicePrivate))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ephemeral := base64.RawStdEncoding.EncodeToString(mustHex(t, bobPublic))
+
+	tests := []struct {
+		name string
+		data *SessionData
+	}{
+		{"empty ciphertext", &SessionData{Ephemeral: ephemeral}},
+		{"partial block", &SessionData{Ephemeral: ephemeral, Ciphertext: "AAAA"}},
+		{"bad padding", &SessionData{Ephemeral: ephemeral, Ciphertext: base64.RawStdEncoding.EncodeToString(make([]byte, 16))}},
+		{"bad ephemeral", &SessionData{Ephemeral: "AAAA", Ciphertext: "9lq9DgATQh0Ey5ZaVGHfoQ"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := key.Decrypt(tt.data); err == nil {
+				t.Error("Decrypt() succeeded, want an error")
+			}
+		})
+	}
+}
+
+func TestPublicKey(t *testing.T) {
+	key, err := KeyFromBytes(mustHex(t, alicePrivate))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := base64.RawStdEncoding.EncodeToString(mustHex(t, alicePublic)); key.PublicKey() != want {
+		t.Errorf("PublicKey() = %q, want %q", key.PublicKey(), want)
+	}
+}
+
+func TestRecoveryKey(t *testing.T) {
+	// The recovery key encoding of the spec: base58 of 0x8B 0x01, the key and a parity byte, in groups of four.
+	const recoveryKey = "EsTL 2cTx 9Qy1 8TVd qGsn GDrD i5dT EEuX Qz8U P7hi Z7uu U8wZ"
+
+	key, err := KeyFromRecoveryKey(recoveryKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := base64.StdEncoding.EncodeToString(key.Bytes()), "QCFDrXZYLEFnwf4NikVm62rYGJS2mNBEmAWLC3CgNPw="; got != want {
+		t.Errorf("Bytes() = %q, want %q", got, want)
+	}
+	if got := key.RecoveryKey(); got != recoveryKey {
+		t.Errorf("RecoveryKey() = %q, want %q", got, recoveryKey)
+	}
+
+	generated, err := NewKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored, err := KeyFromRecoveryKey(generated.RecoveryKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(restored.Bytes(), generated.Bytes()) {
+		t.Error("KeyFromRecoveryKey(RecoveryKey()) returned another key")
+	}
+}
+
+func TestInvalidRecoveryKey(t *testing.T) {
+	for _, recoveryKey := range []string{"", "foo", "EsTL 2cTx 9Qy1 8TVd qGsn GDrD i5dT EEuX Qz8U P7hi Z7uu U8wa"} {
+		if _, err := KeyFromRecoveryKey(recoveryKey); !errors.Is(err, errInvalidRecoveryKey) {
+			t.Errorf("KeyFromRecoveryKey(%q) error = %v, want %v", recoveryKey, err, errInvalidRecoveryKey)
+		}
+	}
+}
@@ -3,7 +3,6 @@ package bot
 import (
 	"bytes"
 	"context"
-	"fmt"
 	"image"
 	"image/png"
 	"io"
@@ -12,8 +11,10 @@ import (
 	"strings"
 
 	"github.com/h2non/filetype"
-	"maunium.net/go/mautrix"
-	"maunium.net/go/mautrix/crypto/attachment"
+	"github.com/mazzz1y/matrix-gpt/internal/gpt"
+	"github.com/mazzz1y/matrix-gpt/internal/redact"
+	"github.com/rs/zerolog/log"
+	"github.com/sashabaranov/go-openai"
 	"maunium.net/go/mautrix/event"
 	"maunium.net/go/mautrix/format"
 	"maunium.net/go/mautrix/id"
@@ -21,6 +22,13 @@ import (
 
 type action func(context.Context, *user, *event.Event, string) error
 
+// actionAliases are short aliases of the original commands. They take precedence over prefix matching,
+// so they keep working as new commands with the same initial letters are added.
+var actionAliases = map[string]string{
+	"i": "image-natural",
+	"r": "reset",
+}
+
 // initBotActions is used to set up the possible actions the Bot can handle.
 // This method should be called during the bot initialization process.
 func (b *Bot) initBotActions() {
@@ -30,35 +38,81 @@ func (b *Bot) initBotActions() {
 		"image-vivid":   b.imageResponse("vivid"),
 		"reset":         b.resetResponse,
 		"help":          b.helpResponse,
+		"room":          b.roomResponse,
+		"schedule":      b.scheduleResponse,
+		"remind":        b.remindResponse,
+		"summarize":     b.summarizeResponse,
+		"translate":     b.translateResponse,
+		"kb":            b.kbResponse,
+		"remember":      b.rememberResponse,
+		"forget":        b.forgetResponse,
+		"memories":      b.memoriesResponse,
+		"export":        b.exportResponse,
+		"import":        b.importResponse,
+		"save":          b.saveResponse,
+		"load":          b.loadResponse,
+		"list":          b.listResponse,
+		"delete":        b.deleteResponse,
+		"feedback":      b.feedbackResponse,
+		"set":           b.setResponse,
+		"settings":      b.settingsResponse,
+		"json":          b.jsonResponse,
+		"moderation":    b.moderationResponse,
+	}
+
+	// actionNames is the order in which actions are matched and ambiguous commands list their candidates.
+	b.actionNames = []string{
+		"", "image-natural", "image-vivid", "reset", "help", "room", "schedule", "remind", "summarize", "translate",
+		"kb", "remember", "forget", "memories", "export", "import", "save", "load", "list", "delete",
+		"feedback", "set", "settings", "json", "moderation",
 	}
 }
 
 // getAction matches an input string to a bot action.
-// It returns an exact match, the longest prefix match, or an unknownCommandError if no match is found.
+// It returns an exact match, an abbreviation or the only prefix match. Prefixes of several actions return an
+// ambiguousCommandError, unless all of them are variants of the same command, and unknown ones an unknownCommandError.
 func (b *Bot) getAction(input string) (action, error) {
-	var bestMatch string
-	for name := range b.actions {
+	if name, ok := actionAliases[input]; ok {
+		return b.actions[name], nil
+	}
+
+	var candidates []string
+	for _, name := range b.actionNames {
 		if input == name || isAbbreviation(input, name) {
 			return b.actions[name], nil
 		}
 
-		if strings.HasPrefix(name, input) && len(name) > len(bestMatch) {
-			bestMatch = name
+		if strings.HasPrefix(name, input) {
+			candidates = append(candidates, name)
 		}
 	}
 
-	if bestMatch == "" {
+	switch {
+	case len(candidates) == 0:
 		return nil, &unknownCommandError{cmd: input}
+	case sameCommand(candidates):
+		return b.actions[candidates[0]], nil
+	default:
+		return nil, &ambiguousCommandError{cmd: input, candidates: candidates}
 	}
+}
 
-	return b.actions[bestMatch], nil
+// sameCommand reports whether the action names are variants of one command, e.g. `image-natural` and `image-vivid`.
+func sameCommand(names []string) bool {
+	first, _, _ := strings.Cut(names[0], "-")
+	for _, name := range names[1:] {
+		if prefix, _, _ := strings.Cut(name, "-"); prefix != first {
+			return false
+		}
+	}
+	return true
 }
 
 // completionResponse responds to a user message with a GPT-based completion.
 // If the message is audio, it transcribes it before generating the response.
 func (b *Bot) completionResponse(ctx context.Context, u *user, evt *event.Event, msg string) error {
 	if evt.Content.AsMessage().MsgType == event.MsgAudio {
-		fname, err := b.decryptAndStoreFile(evt)
+		fname, err := b.downloadAndStoreFile(evt)
 		if err != nil {
 			return err
 		}
@@ -72,13 +126,79 @@ func (b *Bot) completionResponse(ctx context.Context, u *user, evt *event.Event,
 		msg = text
 	}
 
-	newHistory, err := b.gptClient.CreateCompletion(ctx, u.history.get(), msg)
+	msg, placeholders := b.redact(u, msg)
+
+	history, generation, err := u.historySnapshot(ctx)
+	if err != nil {
+		return err
+	}
+
+	newHistory, answer, err := b.complete(ctx, evt, placeholders, history, msg, gpt.Params{})
 	if err != nil {
 		return err
 	}
+	answer = placeholders.Restore(answer)
 
-	u.history.save(newHistory)
-	return b.markdownResponse(evt, false, newHistory[len(newHistory)-1].Content)
+	answerID, err := b.sendAnswer(evt, answer)
+	if err != nil {
+		return err
+	}
+
+	// The turn lock is only held to add the turn, so the requests of a user are completed in parallel.
+	unlock, err := u.lockTurn(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if !u.history.appendTurn(generation, newHistory[len(history):], evt.ID, answerID) {
+		log.Debug().Str("user-id", evt.Sender.String()).Msg("history replaced during the request, turn not saved")
+		return nil
+	}
+	b.recordAnswer(ctx, evt, answerID, newHistory)
+	return nil
+}
+
+// complete generates an answer to the message with the room settings, the generation parameters and memories
+// of the sender and the knowledge base, redacting the knowledge base excerpts with the placeholders of the message.
+// It returns the new history and the answer including its sources.
+// The parameters set in override take precedence over the ones of the sender.
+// The history limit of the room only applies to the messages sent to the model, the returned history is complete.
+func (b *Bot) complete(ctx context.Context, evt *event.Event, placeholders *redact.Mapping, history []openai.ChatCompletionMessage, msg string, override gpt.Params) ([]openai.ChatCompletionMessage, string, error) {
+	settings := b.getRoomSettings(evt.RoomID)
+	sent := history
+	if settings.HistoryLimit > 0 && len(sent) > settings.HistoryLimit {
+		sent = sent[len(sent)-settings.HistoryLimit:]
+	}
+
+	g, err := b.userGeneration(ctx, evt.Sender.String(), settings)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if err := b.moderate(ctx, evt, moderationPrompt, msg); err != nil {
+		return nil, "", err
+	}
+
+	systemPrompt := b.withMemories(ctx, evt.Sender.String(), g.Prompt)
+	systemPrompt, sources := b.retrieveKnowledge(ctx, placeholders, systemPrompt, msg)
+	turn, err := b.gptClient.CreateCompletion(ctx, sent[:len(sent):len(sent)], msg, gpt.CompletionOptions{
+		Model:        g.Model,
+		SystemPrompt: systemPrompt,
+		SaveMemory:   b.memoryTool(ctx, evt.Sender.String()),
+		Params:       g.Params.Merge(override),
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	answer := turn[len(turn)-1].Content
+	if err := b.moderate(ctx, evt, moderationAnswer, answer); err != nil {
+		return nil, "", err
+	}
+
+	newHistory := append(history[:len(history):len(history)], turn[len(sent):]...)
+	return newHistory, answer + sources, nil
 }
 
 // helpResponse responds with help message.
@@ -89,6 +209,12 @@ func (b *Bot) helpResponse(ctx context.Context, u *user, evt *event.Event, msg s
 // imageResponse responds to the user message with a DALL-E created image.
 func (b *Bot) imageResponse(style string) action {
 	return func(ctx context.Context, u *user, evt *event.Event, msg string) error {
+		// The image can't be restored, the placeholders are only kept out of the prompt.
+		msg, _ = b.redact(u, msg)
+		if err := b.moderate(ctx, evt, moderationImage, msg); err != nil {
+			return err
+		}
+
 		url, err := b.gptClient.CreateImage(ctx, style, msg)
 		if err != nil {
 			return err
@@ -106,24 +232,10 @@ func (b *Bot) imageResponse(style string) action {
 
 		content := b.createImageMessageContent(imageBytes, cfg)
 
-		file := attachment.NewEncryptedFile()
-		file.EncryptInPlace(imageBytes)
-
-		req := mautrix.ReqUploadMedia{
-			ContentBytes: imageBytes,
-			ContentType:  "application/octet-stream",
-		}
-
-		upload, err := b.client.UploadMedia(req)
-		if err != nil {
+		if err := b.uploadFile(evt, content, imageBytes, content.Info.MimeType); err != nil {
 			return err
 		}
 
-		content.File = &event.EncryptedFileInfo{
-			EncryptedFile: *file,
-			URL:           upload.ContentURI.CUString(),
-		}
-
 		_, err = b.client.SendMessageEvent(evt.RoomID, event.EventMessage, content)
 		return err
 	}
@@ -132,7 +244,13 @@ func (b *Bot) imageResponse(style string) action {
 // resetResponse clears the user's history. If a message is provided, it's processed as a new input.
 // Otherwise, a reaction is sent to indicate successful history reset.
 func (b *Bot) resetResponse(ctx context.Context, u *user, evt *event.Event, msg string) error {
-	u.history.reset()
+	unlock, err := u.lockTurn(ctx)
+	if err != nil {
+		return err
+	}
+	u.resetHistory()
+	unlock()
+
 	if msg != "" {
 		return b.completionResponse(ctx, u, evt, msg)
 	} else {
@@ -144,7 +262,10 @@ func (b *Bot) resetResponse(ctx context.Context, u *user, evt *event.Event, msg
 // markdownResponse sends a message response in markdown format.
 func (b *Bot) markdownResponse(evt *event.Event, reply bool, msg string) error {
 	formattedMsg := format.RenderMarkdown(msg, true, false)
-	if reply {
+	switch {
+	case evt.ID == "":
+		formattedMsg.RelatesTo = scheduledRelation(evt)
+	case reply:
 		formattedMsg.SetReply(evt)
 	}
 
@@ -152,9 +273,11 @@ func (b *Bot) markdownResponse(evt *event.Event, reply bool, msg string) error {
 	return err
 }
 
-// reactionResponse sends a reaction to a message.
+// reactionResponse sends a reaction to a message. Scheduled messages have no event to react to.
 func (b *Bot) reactionResponse(evt *event.Event, emoji string) {
-	_, _ = b.client.SendReaction(evt.RoomID, evt.ID, emoji)
+	if evt.ID != "" {
+		_, _ = b.client.SendReaction(evt.RoomID, evt.ID, emoji)
+	}
 }
 
 // markRead marks the given event as read by the bot.
@@ -201,25 +324,9 @@ func getImageBytesFromURL(url string) ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
-// decryptAndStoreFile decrypts a file from an event and stores it locally in temp dir, returning the local file name.
-func (b *Bot) decryptAndStoreFile(evt *event.Event) (string, error) {
-	file := evt.Content.AsMessage().File
-
-	if file == nil {
-		return "", fmt.Errorf("no file found in message")
-	}
-
-	mxc, err := file.URL.Parse()
-	if err != nil {
-		return "", err
-	}
-
-	data, err := b.client.DownloadBytes(mxc)
-	if err != nil {
-		return "", err
-	}
-
-	err = file.DecryptInPlace(data)
+// downloadAndStoreFile downloads a file from an event and stores it locally in temp dir, returning the local file name.
+func (b *Bot) downloadAndStoreFile(evt *event.Event) (string, error) {
+	data, err := b.downloadFile(evt)
 	if err != nil {
 		return "", err
 	}
@@ -250,10 +357,11 @@ func storeFile(data []byte) (fname string, err error) {
 
 // isAbbreviation checks if the input is a valid abbreviation of the action name
 // by matching the input with the initials of hyphen-separated parts in the action name.
+// Single-word names have no abbreviation, their prefixes are matched instead.
 func isAbbreviation(input, actionName string) bool {
 	nameParts := strings.Split(actionName, "-")
 
-	if len(input) != len(nameParts) {
+	if len(nameParts) < 2 || len(input) != len(nameParts) {
 		return false
 	}
 
@@ -0,0 +1,295 @@
+package bot
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mazzz1y/matrix-gpt/internal/gpt"
+	"github.com/rs/zerolog/log"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// maxAPIBodySize is the maximum size of an API request body.
+const maxAPIBodySize = 1 << 20
+
+var (
+	errBadRequest   = errors.New("invalid request body")
+	errShuttingDown = errors.New("shutting down")
+)
+
+// apiPromptRequest is the body of POST /v1/rooms/{room}/prompt.
+type apiPromptRequest struct {
+	Prompt string `json:"prompt"`
+}
+
+// apiMessageRequest is the body of POST /v1/rooms/{room}/message.
+type apiMessageRequest struct {
+	Message string `json:"message"`
+}
+
+// apiResponse is the body returned by the API endpoints.
+type apiResponse struct {
+	EventID  id.EventID `json:"event_id,omitempty"`
+	Response string     `json:"response,omitempty"`
+	Error    string     `json:"error,omitempty"`
+}
+
+// rateLimiter is a token bucket per API token, refilled at a fixed rate per minute.
+type rateLimiter struct {
+	sync.Mutex
+	perMinute int
+	buckets   map[string]*bucket
+	// now returns the current time, it is replaced in tests.
+	now func() time.Time
+}
+
+// bucket holds the remaining requests of a single API token.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// newRateLimiter creates a limiter allowing perMinute requests per key. 0 means unlimited.
+func newRateLimiter(perMinute int) *rateLimiter {
+	return &rateLimiter{perMinute: perMinute, buckets: make(map[string]*bucket), now: time.Now}
+}
+
+// allow reports whether a request with the key is allowed, consuming one token if so.
+func (l *rateLimiter) allow(key string) bool {
+	if l.perMinute <= 0 {
+		return true
+	}
+
+	l.Lock()
+	defer l.Unlock()
+
+	now := l.now()
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(l.perMinute), last: now}
+		l.buckets[key] = b
+	}
+
+	b.tokens += now.Sub(b.last).Minutes() * float64(l.perMinute)
+	if b.tokens > float64(l.perMinute) {
+		b.tokens = float64(l.perMinute)
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// apiHandler serves the API endpoints under /v1/rooms/.
+func (b *Bot) apiHandler(w http.ResponseWriter, r *http.Request) {
+	token, ok := b.authenticate(r)
+	if !ok {
+		writeAPIResponse(w, http.StatusUnauthorized, apiResponse{Error: "unauthorized"})
+		return
+	}
+	if !b.apiLimiter.allow(token) {
+		writeAPIResponse(w, http.StatusTooManyRequests, apiResponse{Error: "rate limit exceeded"})
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeAPIResponse(w, http.StatusMethodNotAllowed, apiResponse{Error: "method not allowed"})
+		return
+	}
+	if b.stopping.Load() {
+		writeAPIResponse(w, http.StatusServiceUnavailable, apiResponse{Error: "shutting down"})
+		return
+	}
+
+	room, endpoint, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, "/v1/rooms/"), "/")
+	if !ok || room == "" {
+		writeAPIResponse(w, http.StatusNotFound, apiResponse{Error: "not found"})
+		return
+	}
+
+	l := log.With().
+		Str("event", "api").
+		Str("endpoint", endpoint).
+		Str("room", room).
+		Logger()
+
+	roomID, err := b.resolveRoom(room)
+	if err != nil {
+		l.Debug().Err(err).Msg("api room error")
+		writeAPIResponse(w, http.StatusNotFound, apiResponse{Error: "room not found"})
+		return
+	}
+	if b.requireEncryption && !b.isEncrypted(&event.Event{RoomID: roomID}) {
+		writeAPIResponse(w, http.StatusForbidden, apiResponse{Error: "room is not encrypted"})
+		return
+	}
+
+	var resp apiResponse
+	switch endpoint {
+	case "prompt":
+		resp, err = b.apiPrompt(r, roomID)
+	case "message":
+		resp, err = b.apiMessage(r, roomID)
+	default:
+		writeAPIResponse(w, http.StatusNotFound, apiResponse{Error: "not found"})
+		return
+	}
+
+	if err != nil {
+		l.Err(err).Msg("api request error")
+		code, msg := apiError(err)
+		writeAPIResponse(w, code, apiResponse{Error: msg})
+		return
+	}
+
+	l.Debug().Msg("api request processed")
+	writeAPIResponse(w, http.StatusOK, resp)
+}
+
+// apiPrompt generates a completion for the prompt using the room settings and posts it to the room.
+func (b *Bot) apiPrompt(r *http.Request, roomID id.RoomID) (apiResponse, error) {
+	var req apiPromptRequest
+	if err := decodeAPIRequest(r, &req); err != nil || req.Prompt == "" {
+		return apiResponse{}, errBadRequest
+	}
+
+	if !b.beginRequest() {
+		return apiResponse{}, errShuttingDown
+	}
+	defer b.inFlight.Done()
+
+	// The request is cancelled if the client disconnects or on forced shutdown.
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	go func() {
+		select {
+		case <-b.reqCtx.Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	if err := b.slots.acquire(ctx); err != nil {
+		return apiResponse{}, err
+	}
+	defer b.slots.release()
+
+	// API prompts are moderated as prompts of the bot user.
+	prompt, placeholders := b.redact(nil, req.Prompt)
+	modEvt := &event.Event{RoomID: roomID, Sender: b.client.UserID}
+	if err := b.moderate(ctx, modEvt, moderationPrompt, prompt); err != nil {
+		return apiResponse{}, err
+	}
+
+	g := b.roomGeneration(b.getRoomSettings(roomID))
+	history, err := b.gptClient.CreateCompletion(ctx, nil, prompt, gpt.CompletionOptions{
+		Model:        g.Model,
+		SystemPrompt: g.Prompt,
+		Params:       g.Params,
+	})
+	if err != nil {
+		return apiResponse{}, err
+	}
+
+	answer := history[len(history)-1].Content
+	if err := b.moderate(ctx, modEvt, moderationAnswer, answer); err != nil {
+		return apiResponse{}, err
+	}
+	answer = placeholders.Restore(answer)
+
+	evtID, err := b.sendMarkdown(roomID, answer)
+	return apiResponse{EventID: evtID, Response: answer}, err
+}
+
+// apiMessage posts the message to the room as is.
+func (b *Bot) apiMessage(r *http.Request, roomID id.RoomID) (apiResponse, error) {
+	var req apiMessageRequest
+	if err := decodeAPIRequest(r, &req); err != nil || req.Message == "" {
+		return apiResponse{}, errBadRequest
+	}
+
+	evtID, err := b.sendMarkdown(roomID, req.Message)
+	return apiResponse{EventID: evtID}, err
+}
+
+// authenticate checks the bearer token of the request against the configured API tokens.
+func (b *Bot) authenticate(r *http.Request) (string, bool) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return "", false
+	}
+
+	for _, t := range b.apiTokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(t)) == 1 {
+			return t, true
+		}
+	}
+
+	return "", false
+}
+
+// resolveRoom returns the room ID for a room ID or alias. The bot must be joined to the room.
+func (b *Bot) resolveRoom(room string) (id.RoomID, error) {
+	roomID := id.RoomID(room)
+	if strings.HasPrefix(room, "#") {
+		resp, err := b.client.ResolveAlias(id.RoomAlias(room))
+		if err != nil {
+			return "", err
+		}
+		roomID = resp.RoomID
+	}
+
+	resp, err := b.client.JoinedRooms()
+	if err != nil {
+		return "", err
+	}
+	for _, joined := range resp.JoinedRooms {
+		if joined == roomID {
+			return roomID, nil
+		}
+	}
+
+	return "", errors.New("bot is not joined to the room")
+}
+
+// apiError maps an error to an HTTP status code and a generic message, so no internal details are returned.
+func apiError(err error) (int, string) {
+	var httpErr mautrix.HTTPError
+	var modErr *moderationError
+	switch {
+	case errors.Is(err, errBadRequest):
+		return http.StatusBadRequest, errBadRequest.Error()
+	case errors.As(err, &modErr):
+		return http.StatusUnprocessableEntity, "blocked by moderation"
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout, "timeout"
+	case errors.Is(err, context.Canceled), errors.Is(err, errShuttingDown):
+		return http.StatusServiceUnavailable, "service unavailable"
+	case errors.As(err, &httpErr):
+		return http.StatusBadGateway, "failed to send the message"
+	default:
+		return http.StatusInternalServerError, "internal error"
+	}
+}
+
+// decodeAPIRequest decodes the JSON body of the request.
+func decodeAPIRequest(r *http.Request, v any) error {
+	return json.NewDecoder(http.MaxBytesReader(nil, r.Body, maxAPIBodySize)).Decode(v)
+}
+
+// writeAPIResponse writes the response as JSON with the given status code.
+func writeAPIResponse(w http.ResponseWriter, code int, resp apiResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(resp)
+}
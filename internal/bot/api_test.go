@@ -0,0 +1,46 @@
+package bot
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	l := newRateLimiter(2)
+	l.now = func() time.Time { return now }
+
+	steps := []struct {
+		name    string
+		advance time.Duration
+		key     string
+		want    bool
+	}{
+		{"first request", 0, "a", true},
+		{"second request", 0, "a", true},
+		{"bucket empty", 0, "a", false},
+		{"other token", 0, "b", true},
+		{"half a token refilled", 15 * time.Second, "a", false},
+		{"one token refilled", 15 * time.Second, "a", true},
+		{"refill used", 0, "a", false},
+		{"refill capped", time.Hour, "a", true},
+		{"second after cap", 0, "a", true},
+		{"empty after cap", 0, "a", false},
+	}
+
+	for _, s := range steps {
+		now = now.Add(s.advance)
+		if got := l.allow(s.key); got != s.want {
+			t.Errorf("%s: allow(%q) = %v, want %v", s.name, s.key, got, s.want)
+		}
+	}
+}
+
+func TestRateLimiterUnlimited(t *testing.T) {
+	l := newRateLimiter(0)
+	for i := 0; i < 100; i++ {
+		if !l.allow("a") {
+			t.Fatalf("request %d refused", i)
+		}
+	}
+}
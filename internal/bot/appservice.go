@@ -0,0 +1,102 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+
+	"github.com/rs/zerolog/log"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/appservice"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// GenerateRegistration creates an appservice registration with new tokens,
+// reserving the bot user ID exclusively for the appservice reachable at url.
+func GenerateRegistration(asID, url, userID string) (*appservice.Registration, error) {
+	localpart, _, err := id.UserID(userID).Parse()
+	if err != nil {
+		return nil, err
+	}
+
+	rateLimited := false
+	reg := appservice.CreateRegistration()
+	reg.ID = asID
+	reg.URL = url
+	reg.SenderLocalpart = localpart
+	reg.RateLimited = &rateLimited
+	reg.Namespaces.UserIDs.Register(regexp.MustCompile("^"+regexp.QuoteMeta(userID)+"$"), true)
+
+	return reg, nil
+}
+
+// newAppservice loads the registration and returns an appservice acting as the configured bot user.
+func newAppservice(cfg Config) (*appservice.AppService, error) {
+	reg, err := appservice.LoadRegistration(cfg.AppserviceRegistration)
+	if err != nil {
+		return nil, err
+	}
+
+	_, domain, err := id.UserID(cfg.UserID).Parse()
+	if err != nil {
+		return nil, err
+	}
+
+	as := appservice.Create()
+	as.Registration = reg
+	as.HomeserverDomain = domain
+	if err := as.SetHomeserverURL(cfg.ServerURL); err != nil {
+		return nil, err
+	}
+
+	if as.BotMXID().String() != cfg.UserID {
+		return nil, fmt.Errorf("registration sender %s does not match the bot user %s", as.BotMXID(), cfg.UserID)
+	}
+
+	if err := as.BotIntent().EnsureRegistered(); err != nil {
+		return nil, err
+	}
+
+	return as, nil
+}
+
+// startAppservice serves the appservice endpoints and passes the pushed events to the handlers.
+// It blocks until the HTTP server fails or the context is cancelled.
+func (b *Bot) startAppservice(ctx context.Context) error {
+	ep := appservice.NewEventProcessor(b.appservice)
+	ep.On(event.EventMessage, b.pushedEvent(b.messageHandler))
+	ep.On(event.EventRedaction, b.pushedEvent(b.redactionHandler))
+	ep.On(event.EventReaction, b.pushedEvent(b.reactionHandler))
+	ep.On(event.StateMember, b.pushedEvent(b.joinRoomHandler))
+	ep.On(settingsEventType, b.pushedEvent(b.settingsHandler))
+	ep.Start()
+	defer ep.Stop()
+
+	srv := &http.Server{Addr: b.appserviceListen, Handler: b.appservice.Router}
+	errCh := make(chan error, 1)
+	go func() {
+		log.Info().Str("appservice-listen", b.appserviceListen).Msg("starting appservice http server")
+		errCh <- srv.ListenAndServe()
+	}()
+
+	b.health.start()
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+		if err := srv.Shutdown(context.Background()); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			return err
+		}
+		return nil
+	}
+}
+
+// pushedEvent adapts a sync event handler to the appservice event processor.
+func (b *Bot) pushedEvent(handler mautrix.EventHandler) appservice.EventHandler {
+	return func(evt *event.Event) {
+		handler(mautrix.EventSourceTimeline, evt)
+	}
+}
@@ -0,0 +1,315 @@
+package bot
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/mazzz1y/matrix-gpt/internal/backup"
+	"github.com/rs/zerolog/log"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/crypto"
+	"maunium.net/go/mautrix/crypto/olm"
+	"maunium.net/go/mautrix/crypto/ssss"
+	"maunium.net/go/mautrix/id"
+)
+
+const (
+	// keyBackupInterval is how often new Megolm sessions are uploaded to the key backup.
+	keyBackupInterval = 5 * time.Minute
+	// keyBackupBatchSize is the maximum number of sessions uploaded in one request.
+	keyBackupBatchSize = 100
+)
+
+// keyBackup is the active server-side key backup version and its key.
+type keyBackup struct {
+	key     *backup.Key
+	version string
+}
+
+// setupKeyBackup finds the key of the current server-side key backup and restores the sessions missing locally.
+// If there is no backup yet, a new one is created and its key is stored in secret storage or written to a file.
+func (b *Bot) setupKeyBackup(ctx context.Context, cfg Config) error {
+	recoveryKey := cfg.RecoveryKey
+
+	var ssssKey *ssss.Key
+	if recoveryKey != "" {
+		var err error
+		if ssssKey, err = b.getSSSSKey(recoveryKey); err != nil {
+			return err
+		}
+	}
+
+	version, err := backup.GetLatestVersion(b.client)
+	if err != nil {
+		return fmt.Errorf("failed to get key backup version: %w", err)
+	}
+
+	if version == nil || version.Algorithm != backup.Algorithm {
+		return b.createKeyBackup(ssssKey, backupKeyPath(cfg.SQLitePath))
+	}
+
+	key, err := b.loadBackupKey(recoveryKey, ssssKey)
+	if err != nil {
+		return err
+	}
+	if key.PublicKey() != version.AuthData.PublicKey {
+		return errors.New("recovery key does not match the key backup")
+	}
+
+	b.keyBackup = &keyBackup{key: key, version: version.Version}
+	log.Info().Str("version", version.Version).Int("count", version.Count).Msg("using key backup")
+
+	return b.restoreKeyBackup(ctx)
+}
+
+// createKeyBackup creates a new backup version with a new key.
+// Without secret storage, the recovery key of the backup is written to keyPath.
+func (b *Bot) createKeyBackup(ssssKey *ssss.Key, keyPath string) error {
+	if ssssKey == nil {
+		if _, err := os.Stat(keyPath); err == nil {
+			return fmt.Errorf("backup key file %s already exists, move it away to create a new key backup", keyPath)
+		}
+	}
+
+	key, err := backup.NewKey()
+	if err != nil {
+		return err
+	}
+
+	authData, err := b.signedAuthData(key)
+	if err != nil {
+		return err
+	}
+
+	version, err := backup.CreateVersion(b.client, authData)
+	if err != nil {
+		return fmt.Errorf("failed to create key backup: %w", err)
+	}
+
+	if ssssKey != nil {
+		secret := base64.StdEncoding.EncodeToString(key.Bytes())
+		if err := b.crypto.Machine().SSSS.SetEncryptedAccountData(backup.SecretType, []byte(secret), ssssKey); err != nil {
+			return fmt.Errorf("failed to store backup key in secret storage: %w", err)
+		}
+	} else if err := saveRecoveryKey(keyPath, key.RecoveryKey()); err != nil {
+		return err
+	}
+	log.Info().Str("version", version).Msg("created key backup")
+
+	b.keyBackup = &keyBackup{key: key, version: version}
+	return nil
+}
+
+// backupKeyPath returns the path of the file the recovery key of a new key backup is written to.
+func backupKeyPath(sqlitePath string) string {
+	return sqlitePath + ".backup-key"
+}
+
+// loadBackupKey returns the backup key, either from secret storage or by decoding the recovery key as a backup key.
+func (b *Bot) loadBackupKey(recoveryKey string, ssssKey *ssss.Key) (*backup.Key, error) {
+	if ssssKey != nil {
+		secret, err := b.crypto.Machine().SSSS.GetDecryptedAccountData(backup.SecretType, ssssKey)
+		if err == nil {
+			raw, err := base64.RawStdEncoding.DecodeString(strings.TrimRight(string(secret), "="))
+			if err != nil {
+				return nil, err
+			}
+			return backup.KeyFromBytes(raw)
+		}
+	}
+
+	if recoveryKey == "" {
+		return nil, errors.New("a key backup exists, but no recovery key is configured")
+	}
+
+	return backup.KeyFromRecoveryKey(recoveryKey)
+}
+
+// signedAuthData returns the backup auth data signed with the device key and, if available, the master key.
+func (b *Bot) signedAuthData(key *backup.Key) (backup.AuthData, error) {
+	mach := b.crypto.Machine()
+	authData := backup.AuthData{PublicKey: key.PublicKey()}
+
+	signatures := map[id.KeyID]string{}
+	sig, err := mach.GetAccount().Internal.SignJSON(authData)
+	if err != nil {
+		return authData, err
+	}
+	signatures[id.NewKeyID(id.KeyAlgorithmEd25519, b.client.DeviceID.String())] = sig
+
+	if mach.CrossSigningKeys != nil {
+		masterKey := mach.CrossSigningKeys.MasterKey
+		sig, err := masterKey.SignJSON(authData)
+		if err != nil {
+			return authData, err
+		}
+		signatures[id.NewKeyID(id.KeyAlgorithmEd25519, masterKey.PublicKey.String())] = sig
+	}
+
+	authData.Signatures = mautrix.Signatures{b.client.UserID: signatures}
+	return authData, nil
+}
+
+// restoreKeyBackup imports the backed up sessions that are missing or less complete in the local store.
+func (b *Bot) restoreKeyBackup(ctx context.Context) error {
+	keys, err := backup.GetKeys(b.client, b.keyBackup.version)
+	if err != nil {
+		return fmt.Errorf("failed to download key backup: %w", err)
+	}
+
+	store := b.crypto.Machine().CryptoStore
+	var restored []string
+	for roomID, room := range keys.Rooms {
+		for sessionID, data := range room.Sessions {
+			igs, err := b.decryptBackedUpSession(roomID, sessionID, data)
+			if err != nil {
+				log.Debug().Err(err).Str("session-id", sessionID.String()).Msg("failed to restore session")
+				continue
+			}
+
+			existing, _ := store.GetGroupSession(roomID, igs.SenderKey, sessionID)
+			if existing == nil || existing.Internal.FirstKnownIndex() > igs.Internal.FirstKnownIndex() {
+				if err := store.PutGroupSession(roomID, igs.SenderKey, sessionID, igs); err != nil {
+					return err
+				}
+			}
+			restored = append(restored, sessionID.String())
+		}
+	}
+
+	log.Info().Int("sessions", len(restored)).Msg("restored key backup")
+	return b.store.MarkSessionsBackedUp(ctx, b.keyBackup.version, restored)
+}
+
+// decryptBackedUpSession decrypts a backed up session into an inbound group session.
+func (b *Bot) decryptBackedUpSession(roomID id.RoomID, sessionID id.SessionID, data backup.KeyBackupData) (*crypto.InboundGroupSession, error) {
+	plaintext, err := b.keyBackup.key.Decrypt(data.SessionData)
+	if err != nil {
+		return nil, err
+	}
+
+	var sk backup.SessionKey
+	if err := json.Unmarshal(plaintext, &sk); err != nil {
+		return nil, err
+	}
+	if sk.Algorithm != id.AlgorithmMegolmV1 {
+		return nil, fmt.Errorf("unsupported algorithm %s", sk.Algorithm)
+	}
+
+	internal, err := olm.InboundGroupSessionImport([]byte(sk.SessionKey))
+	if err != nil {
+		return nil, err
+	}
+	if internal.ID() != sessionID {
+		return nil, errors.New("mismatching session ID")
+	}
+
+	return &crypto.InboundGroupSession{
+		Internal:         *internal,
+		SigningKey:       id.Ed25519(sk.SenderClaimedKeys["ed25519"]),
+		SenderKey:        sk.SenderKey,
+		RoomID:           roomID,
+		ForwardingChains: sk.ForwardingChains,
+		ReceivedAt:       time.Now().UTC(),
+	}, nil
+}
+
+// keyBackupLoop periodically uploads new Megolm sessions until the context is cancelled.
+func (b *Bot) keyBackupLoop(ctx context.Context) {
+	ticker := time.NewTicker(keyBackupInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := b.uploadKeyBackup(ctx); err != nil {
+			log.Err(err).Msg("key backup upload error")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// uploadKeyBackup uploads the sessions that are not backed up yet in batches.
+func (b *Bot) uploadKeyBackup(ctx context.Context) error {
+	store := b.crypto.Machine().CryptoStore
+
+	for {
+		sessions, err := b.store.GetSessionsNotBackedUp(ctx, b.keyBackup.version, keyBackupBatchSize)
+		if err != nil || len(sessions) == 0 {
+			return err
+		}
+
+		keys := &backup.Keys{Rooms: map[id.RoomID]backup.RoomKeys{}}
+		ids := make([]string, 0, len(sessions))
+		for _, s := range sessions {
+			ids = append(ids, s.SessionID)
+
+			roomID := id.RoomID(s.RoomID)
+			igs, err := store.GetGroupSession(roomID, id.SenderKey(s.SenderKey), id.SessionID(s.SessionID))
+			if err != nil || igs == nil {
+				continue
+			}
+
+			data, err := b.encryptSession(igs)
+			if err != nil {
+				return err
+			}
+
+			room, ok := keys.Rooms[roomID]
+			if !ok {
+				room = backup.RoomKeys{Sessions: map[id.SessionID]backup.KeyBackupData{}}
+				keys.Rooms[roomID] = room
+			}
+			room.Sessions[igs.ID()] = *data
+		}
+
+		if err := backup.PutKeys(b.client, b.keyBackup.version, keys); err != nil {
+			return err
+		}
+		if err := b.store.MarkSessionsBackedUp(ctx, b.keyBackup.version, ids); err != nil {
+			return err
+		}
+
+		log.Debug().Int("sessions", len(ids)).Msg("uploaded sessions to key backup")
+	}
+}
+
+// encryptSession exports an inbound group session and encrypts it with the backup key.
+func (b *Bot) encryptSession(igs *crypto.InboundGroupSession) (*backup.KeyBackupData, error) {
+	firstIndex := igs.Internal.FirstKnownIndex()
+	sessionKey, err := igs.Internal.Export(firstIndex)
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := json.Marshal(backup.SessionKey{
+		Algorithm:         id.AlgorithmMegolmV1,
+		ForwardingChains:  igs.ForwardingChains,
+		SenderKey:         igs.SenderKey,
+		SenderClaimedKeys: map[string]string{"ed25519": igs.SigningKey.String()},
+		SessionKey:        string(sessionKey),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := b.keyBackup.key.Encrypt(plaintext)
+	if err != nil {
+		return nil, err
+	}
+
+	return &backup.KeyBackupData{
+		FirstMessageIndex: firstIndex,
+		ForwardedCount:    len(igs.ForwardingChains),
+		SessionData:       data,
+	}, nil
+}
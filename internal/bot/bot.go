@@ -1,48 +1,232 @@
 package bot
 
 import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/mazzz1y/matrix-gpt/internal/gpt"
+	"github.com/mazzz1y/matrix-gpt/internal/kb"
+	"github.com/mazzz1y/matrix-gpt/internal/redact"
+	"github.com/mazzz1y/matrix-gpt/internal/store"
 	"github.com/rs/zerolog/log"
 	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/appservice"
 	"maunium.net/go/mautrix/crypto/cryptohelper"
 	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
 )
 
 type Bot struct {
 	client        *mautrix.Client
+	crypto        *cryptohelper.CryptoHelper
+	appservice    *appservice.AppService
+	store         *store.Store
 	gptClient     *gpt.Gpt
 	selfProfile   mautrix.RespUserProfile
 	historyExpire time.Duration
 	users         map[string]*user
+	slots         semaphore
 	actions       map[string]action
+	actionNames   []string
+	health        *health
+	invitePolicy  invitePolicy
+	settings      *settingsCache
+	apiTokens     []string
+	adminIDs      []string
+	apiLimiter    *rateLimiter
+	kb            *kb.KB
+	kbTopK        int
+	kbMinScore    float32
+	defaultParams gpt.Params
+	personas      map[string]persona
+	allowedModels map[string]bool
+	schemas       map[string]*gpt.Schema
+	moderation    moderationConfig
+	redactor      *redact.Redactor
+
+	// memoryToolEnabled lets the model save facts about users with a tool call.
+	memoryToolEnabled bool
+	// answerRetention is how long recorded answers can be rated, 0 keeps them forever.
+	answerRetention time.Duration
+	// customPersonas lets rooms set a free-text system prompt as persona, not only the names of the personas.
+	customPersonas bool
+
+	// requireEncryption makes the bot refuse to respond in unencrypted rooms.
+	requireEncryption bool
+	keyBackup         *keyBackup
+	// autoConfirmSAS makes the bot accept verifications from allowed users without comparing the SAS.
+	autoConfirmSAS bool
+
+	// reqCtx is the parent context of all user requests, it is cancelled on forced shutdown.
+	reqCtx    context.Context
+	reqCancel context.CancelFunc
+	inFlight  sync.WaitGroup
+	stopping  atomic.Bool
+	// stopMu makes checking stopping and adding to inFlight atomic with respect to Shutdown.
+	stopMu sync.Mutex
+
+	// appserviceListen is the address of the appservice HTTP server.
+	appserviceListen string
+}
+
+// Config holds the Matrix bot configuration.
+type Config struct {
+	ServerURL  string
+	UserID     string
+	SQLitePath string
+
+	// AccessToken and DeviceID are used instead of a stored session or a password login if set.
+	AccessToken string
+	DeviceID    string
+	// Password is used to log in if there is neither an access token nor a stored session.
+	Password string
+
+	// PickleKey or the contents of PickleKeyFile encrypt the Olm/Megolm sessions in the crypto store.
+	// If both are empty, a key file is generated next to the database.
+	PickleKey     string
+	PickleKeyFile string
+	// AllowLegacyPickleKey lets stores created by previous versions keep using LegacyPickleKey.
+	AllowLegacyPickleKey bool
+	// RecoveryKey unlocks the cross-signing keys in secret storage to sign the bot device.
+	RecoveryKey string
+	// ResetCrossSigning generates new cross-signing keys even if the account already has some.
+	ResetCrossSigning bool
+	// AutoConfirmSAS accepts emoji and number verifications from allowed users without comparing them.
+	AutoConfirmSAS bool
+	// InviteDMOnly, InviteMaxMembers and InviteServers restrict the rooms the bot joins and stays in.
+	// InviteMaxMembers and InviteServers are ignored if zero or empty.
+	InviteDMOnly     bool
+	InviteMaxMembers int
+	InviteServers    []string
+
+	// EncryptionPolicy is either EncryptionPolicyAllow or EncryptionPolicyRequire.
+	EncryptionPolicy string
+	// KeyBackup enables uploading Megolm sessions to the server-side key backup and restoring them on startup.
+	KeyBackup bool
+
+	// HistoryExpire is the time after which history entries expire (in hours).
+	HistoryExpire int
+	// HistoryLimit is the maximum number of history entries.
+	HistoryLimit int
+	// AnswerRetention is the number of days recorded answers are kept for feedback, 0 keeps them forever.
+	AnswerRetention int
+	// UserIDs is the list of allowed Matrix user IDs.
+	UserIDs []string
+	// AdminIDs is the list of allowed users that may use admin commands.
+	AdminIDs []string
+
+	// UserConcurrency is the number of requests processed in parallel per user, 0 is unlimited.
+	UserConcurrency int
+	// MaxConcurrency is the number of requests processed in parallel across all users, 0 is unlimited.
+	MaxConcurrency int
+
+	// APITokens are the bearer tokens accepted by the HTTP API. The API is disabled if empty.
+	APITokens []string
+	// APIRateLimit is the number of API requests allowed per token and minute, 0 is unlimited.
+	APIRateLimit int
+
+	// KBTopK is the number of knowledge base excerpts added to completions, 0 disables the retrieval.
+	KBTopK int
+	// KBMinScore is the minimum similarity of an excerpt to the message to be added.
+	KBMinScore float64
+
+	// MemoryTool lets the model save facts about users on its own, in addition to the `!remember` command.
+	MemoryTool bool
+
+	// PersonasFile is the path of a YAML file with the default generation parameters and named personas.
+	PersonasFile string
+	// CustomPersonas lets rooms set a free-text system prompt as persona, not only the names of the personas.
+	CustomPersonas bool
+	// AllowedModels are the models rooms may select. If empty, the default model and the models of the personas
+	// are allowed.
+	AllowedModels []string
+	// SchemasFile is the path of a YAML file with the named JSON schemas for the `!json` command.
+	SchemasFile string
+
+	// Moderation is either empty to disable moderation, ModerationFlag or ModerationBlock.
+	Moderation string
+	// ModerationThresholds are minimum category scores in the form `category=score`. Categories without a threshold
+	// match if the moderation endpoint flags them.
+	ModerationThresholds []string
+	// ModerationAnswers and ModerationImages also moderate answers and image prompts, not only completion prompts.
+	ModerationAnswers bool
+	ModerationImages  bool
+
+	// Redact enables replacing personal data and secrets in user messages with placeholders before they are sent.
+	// RedactDetectors, RedactDomains, RedactPatterns and RedactKeywords configure the rules, see redact.Config.
+	Redact          bool
+	RedactDetectors []string
+	RedactDomains   []string
+	RedactPatterns  []string
+	RedactKeywords  []string
+
+	// AppserviceRegistration is the path of the appservice registration file. If set, the bot runs as an appservice
+	// and receives events via AppserviceListen instead of syncing. End-to-end encryption is not available in this mode.
+	AppserviceRegistration string
+	AppserviceListen       string
 }
 
 // NewBot initializes a new Matrix bot instance.
-func NewBot(serverUrl, userID, password, sqlitePath string, historyExpire, historyLimit int, userIDs []string, gpt *gpt.Gpt) (*Bot, error) {
-	client, err := mautrix.NewClient(serverUrl, "", "")
+func NewBot(cfg Config, gpt *gpt.Gpt) (*Bot, error) {
+	ctx := context.Background()
+
+	if err := checkEncryptionPolicy(cfg.EncryptionPolicy, cfg.AppserviceRegistration != ""); err != nil {
+		return nil, err
+	}
+
+	if err := checkModerationMode(cfg.Moderation); err != nil {
+		return nil, err
+	}
+	thresholds, err := parseModerationThresholds(cfg.ModerationThresholds)
 	if err != nil {
 		return nil, err
 	}
 
-	crypto, err := cryptohelper.NewCryptoHelper(client, []byte("1337"), sqlitePath)
+	redactor, err := newRedactor(cfg)
 	if err != nil {
 		return nil, err
 	}
 
-	crypto.LoginAs = &mautrix.ReqLogin{
-		Type:       mautrix.AuthTypePassword,
-		Identifier: mautrix.UserIdentifier{Type: mautrix.IdentifierTypeUser, User: userID},
-		Password:   password,
+	defaultParams, personas, err := loadPersonas(cfg.PersonasFile)
+	if err != nil {
+		return nil, err
 	}
 
-	if err := crypto.Init(); err != nil {
+	schemas, err := loadSchemas(cfg.SchemasFile)
+	if err != nil {
 		return nil, err
 	}
 
-	client.Crypto = crypto
+	st, err := store.New(cfg.SQLitePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		client *mautrix.Client
+		crypto *cryptohelper.CryptoHelper
+		as     *appservice.AppService
+	)
+	if cfg.AppserviceRegistration != "" {
+		as, err = newAppservice(cfg)
+		if err != nil {
+			return nil, err
+		}
+		client = as.BotClient()
+	} else {
+		client, crypto, err = newCryptoClient(ctx, st, cfg)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	h := &health{appservice: as != nil}
+	h.setCryptoReady(crypto != nil)
+
 	profile, err := client.GetProfile(client.UserID)
 	if err != nil {
 		return nil, err
@@ -52,31 +236,204 @@ func NewBot(serverUrl, userID, password, sqlitePath string, historyExpire, histo
 		Str("matrix-username", profile.DisplayName).
 		Str("gpt-model", gpt.GetModel()).
 		Float64("gpt-timeout", gpt.GetTimeout().Seconds()).
-		Int("history-limit", historyLimit).
-		Int("history-expire", historyExpire).
+		Int("history-limit", cfg.HistoryLimit).
+		Int("history-expire", cfg.HistoryExpire).
+		Int("user-concurrency", cfg.UserConcurrency).
+		Int("max-concurrency", cfg.MaxConcurrency).
+		Bool("appservice", as != nil).
+		Str("encryption-policy", cfg.EncryptionPolicy).
+		Str("moderation", cfg.Moderation).
+		Bool("redact", cfg.Redact).
+		Int("personas", len(personas)).
+		Int("schemas", len(schemas)).
 		Msg("connected to matrix")
 
 	users := make(map[string]*user)
-	for _, id := range userIDs {
-		users[id] = newGptUser(historyLimit)
+	for _, id := range cfg.UserIDs {
+		users[id] = newGptUser(cfg.HistoryLimit, cfg.UserConcurrency)
 	}
 
-	return &Bot{
+	reqCtx, reqCancel := context.WithCancel(context.Background())
+
+	b := &Bot{
 		client:        client,
+		crypto:        crypto,
+		appservice:    as,
+		store:         st,
 		gptClient:     gpt,
 		selfProfile:   *profile,
 		users:         users,
-		historyExpire: time.Duration(historyExpire) * time.Hour,
-	}, nil
+		slots:         newSemaphore(cfg.MaxConcurrency),
+		historyExpire: time.Duration(cfg.HistoryExpire) * time.Hour,
+		health:        h,
+		settings:      &settingsCache{rooms: make(map[id.RoomID]roomSettings)},
+		apiTokens:     cfg.APITokens,
+		adminIDs:      cfg.AdminIDs,
+		apiLimiter:    newRateLimiter(cfg.APIRateLimit),
+		kb:            kb.New(st, redactingEmbedder{embedder: gpt, redactor: redactor}),
+		kbTopK:        cfg.KBTopK,
+		kbMinScore:    float32(cfg.KBMinScore),
+		defaultParams: defaultParams,
+		personas:      personas,
+		allowedModels: allowedModels(cfg.AllowedModels, gpt.GetModel(), personas),
+		schemas:       schemas,
+		redactor:      redactor,
+		reqCtx:        reqCtx,
+		reqCancel:     reqCancel,
+
+		answerRetention: time.Duration(cfg.AnswerRetention) * 24 * time.Hour,
+
+		invitePolicy: invitePolicy{
+			dmOnly:     cfg.InviteDMOnly,
+			maxMembers: cfg.InviteMaxMembers,
+			servers:    cfg.InviteServers,
+		},
+		moderation: moderationConfig{
+			mode:       cfg.Moderation,
+			thresholds: thresholds,
+			answers:    cfg.ModerationAnswers,
+			images:     cfg.ModerationImages,
+		},
+		requireEncryption: cfg.EncryptionPolicy == EncryptionPolicyRequire,
+		autoConfirmSAS:    cfg.AutoConfirmSAS,
+		memoryToolEnabled: cfg.MemoryTool,
+		customPersonas:    cfg.CustomPersonas,
+		appserviceListen:  cfg.AppserviceListen,
+	}
+
+	if crypto == nil {
+		return b, nil
+	}
+
+	crypto.Machine().AcceptVerificationFrom = b.acceptVerificationFrom
+	if err := b.setupCrossSigning(cfg); err != nil {
+		log.Err(err).Msg("cross-signing setup error")
+	}
+	if cfg.KeyBackup {
+		if err := b.setupKeyBackup(ctx, cfg); err != nil {
+			log.Err(err).Msg("key backup setup error")
+		}
+	}
+
+	return b, nil
+}
+
+// newCryptoClient logs in and returns a client with end-to-end encryption set up.
+func newCryptoClient(ctx context.Context, st *store.Store, cfg Config) (*mautrix.Client, *cryptohelper.CryptoHelper, error) {
+	client, err := mautrix.NewClient(cfg.ServerURL, "", "")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pickleKey, err := loadPickleKey(ctx, st, cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	crypto, err := cryptohelper.NewCryptoHelper(client, pickleKey, st.DB())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	passwordLogin, err := configureLogin(ctx, client, crypto, st, cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := crypto.Init(); err != nil {
+		return nil, nil, err
+	}
+
+	if passwordLogin {
+		if err := saveSession(ctx, client, st); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	client.Crypto = crypto
+	return client, crypto, nil
 }
 
 // StartHandler initializes bot event handlers and starts the matrix client sync.
-func (b *Bot) StartHandler() error {
+// It blocks until the sync fails or the context is cancelled.
+func (b *Bot) StartHandler(ctx context.Context) error {
 	b.initBotActions()
 
+	go b.checkJoinedRooms()
+	go b.schedulerLoop(ctx)
+
+	if b.appservice != nil {
+		return b.startAppservice(ctx)
+	}
+
 	syncer := b.client.Syncer.(*mautrix.DefaultSyncer)
 	syncer.OnEventType(event.EventMessage, b.messageHandler)
 	syncer.OnEventType(event.EventRedaction, b.redactionHandler)
+	syncer.OnEventType(event.EventReaction, b.reactionHandler)
 	syncer.OnEventType(event.StateMember, b.joinRoomHandler)
-	return b.client.Sync()
+	syncer.OnEventType(settingsEventType, b.settingsHandler)
+	for _, t := range []event.Type{
+		event.InRoomVerificationStart, event.InRoomVerificationReady, event.InRoomVerificationAccept,
+		event.InRoomVerificationKey, event.InRoomVerificationMAC, event.InRoomVerificationCancel,
+	} {
+		syncer.OnEventType(t, b.verificationHandler)
+	}
+	syncer.OnSync(b.health.syncHandler)
+
+	if b.keyBackup != nil {
+		go b.keyBackupLoop(ctx)
+	}
+
+	b.health.start()
+	err := b.client.SyncWithContext(ctx)
+	if errors.Is(err, context.Canceled) {
+		return nil
+	}
+	return err
+}
+
+// beginRequest adds a request to inFlight, unless the bot is shutting down. The caller must call inFlight.Done
+// if it returns true.
+func (b *Bot) beginRequest() bool {
+	b.stopMu.Lock()
+	defer b.stopMu.Unlock()
+
+	if b.stopping.Load() {
+		return false
+	}
+	b.inFlight.Add(1)
+	return true
+}
+
+// Shutdown stops the sync, rejects new events and waits for in-flight requests to finish.
+// If the context expires first, the remaining requests are cancelled.
+// Finally, the crypto store and the database shared with it are closed.
+func (b *Bot) Shutdown(ctx context.Context) error {
+	b.stopMu.Lock()
+	b.stopping.Store(true)
+	b.stopMu.Unlock()
+	b.client.StopSync()
+
+	done := make(chan struct{})
+	go func() {
+		b.inFlight.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		log.Info().Msg("all requests finished")
+	case <-ctx.Done():
+		log.Warn().Msg("shutdown timeout exceeded, cancelling requests")
+		b.reqCancel()
+		<-done
+	}
+
+	b.reqCancel()
+	if b.crypto != nil {
+		if err := b.crypto.Close(); err != nil {
+			return err
+		}
+	}
+	return b.store.Close()
 }
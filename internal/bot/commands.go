@@ -3,6 +3,8 @@ package bot
 import (
 	"fmt"
 	"strings"
+
+	"maunium.net/go/mautrix/event"
 )
 
 type unknownCommandError struct {
@@ -13,6 +15,16 @@ func (e *unknownCommandError) Error() string {
 	return fmt.Sprintf("command '!%s' does not exist", e.cmd)
 }
 
+// ambiguousCommandError is returned if a command is a prefix of several actions.
+type ambiguousCommandError struct {
+	cmd        string
+	candidates []string
+}
+
+func (e *ambiguousCommandError) Error() string {
+	return fmt.Sprintf("command `!%s` is ambiguous, did you mean `!%s`?", e.cmd, strings.Join(e.candidates, "`, `!"))
+}
+
 func extractCommand(s string) (cmd string) {
 	if strings.HasPrefix(s, "!") && len(s) > 1 {
 		//Get the word after '!'
@@ -30,3 +42,12 @@ func trimCommand(s string) string {
 	}
 	return s
 }
+
+// commandBody returns the body of the message. Commands sent as a reply are returned without the quoted
+// reply fallback some clients prepend, other messages keep it as context.
+func commandBody(content *event.MessageEventContent) string {
+	if trimmed := event.TrimReplyFallbackText(content.Body); extractCommand(trimmed) != "" {
+		return trimmed
+	}
+	return content.Body
+}
@@ -0,0 +1,128 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+	"maunium.net/go/mautrix/event"
+)
+
+const (
+	// maxConversations is the maximum number of saved conversations per user.
+	maxConversations = 20
+	// maxConversationName is the maximum length of a conversation name.
+	maxConversationName = 64
+)
+
+// saveResponse saves the current history of the user under a name, e.g. `!save debugging`.
+func (b *Bot) saveResponse(ctx context.Context, u *user, evt *event.Event, msg string) error {
+	if !validConversationName(msg) {
+		return b.markdownResponse(evt, true, savedUsageMsg)
+	}
+
+	history := u.history.get()
+	if len(history) == 0 {
+		return b.markdownResponse(evt, true, "There is no conversation to save.")
+	}
+
+	userID := evt.Sender.String()
+	existing, err := b.store.GetConversation(ctx, userID, msg)
+	if err != nil {
+		return err
+	}
+	if existing == nil {
+		conversations, err := b.store.GetConversations(ctx, userID)
+		if err != nil {
+			return err
+		}
+		if len(conversations) >= maxConversations {
+			return b.markdownResponse(evt, true,
+				fmt.Sprintf("You can't save more than %d conversations, use `!delete <name>` to remove some.", maxConversations))
+		}
+	}
+
+	data, err := json.Marshal(history)
+	if err != nil {
+		return err
+	}
+	if err := b.store.SaveConversation(ctx, userID, msg, data); err != nil {
+		return err
+	}
+
+	b.reactionResponse(evt, "✅")
+	return nil
+}
+
+// loadResponse replaces the current history of the user with a saved conversation.
+func (b *Bot) loadResponse(ctx context.Context, u *user, evt *event.Event, msg string) error {
+	if !validConversationName(msg) {
+		return b.markdownResponse(evt, true, savedUsageMsg)
+	}
+
+	c, err := b.store.GetConversation(ctx, evt.Sender.String(), msg)
+	if err != nil {
+		return err
+	}
+	if c == nil {
+		return b.markdownResponse(evt, true, fmt.Sprintf("Conversation `%s` not found.", msg))
+	}
+
+	var history []openai.ChatCompletionMessage
+	if err := json.Unmarshal(c.Messages, &history); err != nil {
+		return err
+	}
+
+	unlock, err := u.lockTurn(ctx)
+	if err != nil {
+		return err
+	}
+	u.history.save(history)
+	unlock()
+
+	return b.markdownResponse(evt, true, fmt.Sprintf("Loaded `%s` (%d messages).", msg, len(history)))
+}
+
+// listResponse lists the saved conversations of the user.
+func (b *Bot) listResponse(ctx context.Context, u *user, evt *event.Event, msg string) error {
+	conversations, err := b.store.GetConversations(ctx, evt.Sender.String())
+	if err != nil {
+		return err
+	}
+	if len(conversations) == 0 {
+		return b.markdownResponse(evt, true, "You have no saved conversations. "+savedUsageMsg)
+	}
+
+	var sb strings.Builder
+	sb.WriteString("**Saved conversations**\n")
+	for _, c := range conversations {
+		fmt.Fprintf(&sb, "- `%s` (%s)\n", c.Name, formatTime(c.UpdatedAt))
+	}
+
+	return b.markdownResponse(evt, false, sb.String())
+}
+
+// deleteResponse deletes a saved conversation of the user.
+func (b *Bot) deleteResponse(ctx context.Context, u *user, evt *event.Event, msg string) error {
+	if !validConversationName(msg) {
+		return b.markdownResponse(evt, true, savedUsageMsg)
+	}
+
+	ok, err := b.store.DeleteConversation(ctx, evt.Sender.String(), msg)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return b.markdownResponse(evt, true, fmt.Sprintf("Conversation `%s` not found.", msg))
+	}
+
+	b.reactionResponse(evt, "✅")
+	return nil
+}
+
+// validConversationName reports whether the name is a single word of acceptable length.
+func validConversationName(name string) bool {
+	return name != "" && len(name) <= maxConversationName && !strings.ContainsAny(name, " \t\n`")
+}
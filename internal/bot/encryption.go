@@ -0,0 +1,123 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/crypto/attachment"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+const (
+	// EncryptionPolicyAllow lets the bot respond in both encrypted and unencrypted rooms.
+	EncryptionPolicyAllow = "allow"
+	// EncryptionPolicyRequire makes the bot refuse to respond in unencrypted rooms.
+	EncryptionPolicyRequire = "require"
+)
+
+// checkEncryptionPolicy validates the policy against the bot mode.
+func checkEncryptionPolicy(policy string, appservice bool) error {
+	switch policy {
+	case "", EncryptionPolicyAllow:
+		return nil
+	case EncryptionPolicyRequire:
+		if appservice {
+			return fmt.Errorf("encryption policy %q is not supported in appservice mode", policy)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown encryption policy %q", policy)
+	}
+}
+
+// isEncrypted reports whether the room of the event is encrypted.
+// Decrypted events are always from encrypted rooms, otherwise the state store is checked.
+func (b *Bot) isEncrypted(evt *event.Event) bool {
+	if evt.Mautrix.WasEncrypted {
+		return true
+	}
+	return b.client.StateStore != nil && b.client.StateStore.IsEncrypted(evt.RoomID)
+}
+
+// uploadFile uploads the data and attaches it to the content, encrypting it first if the room is encrypted.
+func (b *Bot) uploadFile(evt *event.Event, content *event.MessageEventContent, data []byte, mimeType string) error {
+	if !b.isEncrypted(evt) {
+		upload, err := b.client.UploadMedia(mautrix.ReqUploadMedia{
+			ContentBytes: data,
+			ContentType:  mimeType,
+		})
+		if err != nil {
+			return err
+		}
+
+		content.URL = upload.ContentURI.CUString()
+		return nil
+	}
+
+	file := attachment.NewEncryptedFile()
+	file.EncryptInPlace(data)
+
+	upload, err := b.client.UploadMedia(mautrix.ReqUploadMedia{
+		ContentBytes: data,
+		ContentType:  "application/octet-stream",
+	})
+	if err != nil {
+		return err
+	}
+
+	content.File = &event.EncryptedFileInfo{
+		EncryptedFile: *file,
+		URL:           upload.ContentURI.CUString(),
+	}
+	return nil
+}
+
+// downloadFile downloads the file attached to a message, decrypting it if needed.
+func (b *Bot) downloadFile(evt *event.Event) ([]byte, error) {
+	content := evt.Content.AsMessage()
+
+	uri := content.URL
+	if content.File != nil {
+		uri = content.File.URL
+	}
+	if uri == "" {
+		return nil, fmt.Errorf("no file found in message")
+	}
+
+	mxc, err := uri.Parse()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := b.client.DownloadBytes(mxc)
+	if err != nil {
+		return nil, err
+	}
+
+	if content.File != nil {
+		if err := content.File.DecryptInPlace(data); err != nil {
+			return nil, err
+		}
+	}
+
+	return data, nil
+}
+
+// decryptEvent parses the content of an event fetched from the room history, decrypting it if needed.
+func (b *Bot) decryptEvent(roomID id.RoomID, evt *event.Event) (*event.Event, error) {
+	evt.RoomID = roomID
+	if err := evt.Content.ParseRaw(evt.Type); err != nil && !errors.Is(err, event.ErrContentAlreadyParsed) {
+		return nil, err
+	}
+
+	if evt.Type != event.EventEncrypted {
+		return evt, nil
+	}
+	if b.crypto == nil {
+		return nil, errors.New("encryption is not supported")
+	}
+
+	return b.crypto.Decrypt(evt)
+}
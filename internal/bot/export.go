@@ -0,0 +1,220 @@
+package bot
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+	"maunium.net/go/mautrix/event"
+)
+
+const (
+	// exportVersion is the version of the JSON export schema.
+	exportVersion = 1
+	// maxImportSize is the maximum size of an imported file in bytes.
+	maxImportSize = 1 << 20
+
+	exportFormatJSON     = "json"
+	exportFormatMarkdown = "markdown"
+)
+
+// conversationExport is the JSON schema of an exported conversation.
+type conversationExport struct {
+	Version       int               `json:"version"`
+	ExportedAt    time.Time         `json:"exported_at"`
+	LastMessageAt time.Time         `json:"last_message_at"`
+	Model         string            `json:"model"`
+	SystemPrompt  string            `json:"system_prompt,omitempty"`
+	Messages      []exportedMessage `json:"messages"`
+}
+
+// exportedMessage is a single history entry of an exported conversation.
+// Time is missing for entries whose time is unknown, e.g. restored from a saved conversation.
+type exportedMessage struct {
+	Role    string     `json:"role"`
+	Content string     `json:"content"`
+	Time    *time.Time `json:"time,omitempty"`
+}
+
+// exportResponse uploads the history of the user as a file, in JSON (default) or Markdown format.
+func (b *Bot) exportResponse(ctx context.Context, u *user, evt *event.Event, msg string) error {
+	exportFormat := exportFormatJSON
+	if msg != "" {
+		exportFormat = msg
+	}
+
+	history, times := u.history.getWithTimes()
+	if len(history) == 0 {
+		return b.markdownResponse(evt, true, "There is no conversation to export.")
+	}
+
+	g := b.roomGeneration(b.getRoomSettings(evt.RoomID))
+	exp := conversationExport{
+		Version:       exportVersion,
+		ExportedAt:    time.Now().UTC(),
+		LastMessageAt: u.getLastMsgTime().UTC(),
+		Model:         g.Model,
+		SystemPrompt:  g.Prompt,
+	}
+	if exp.Model == "" {
+		exp.Model = b.gptClient.GetModel()
+	}
+	for i, m := range history {
+		em := exportedMessage{Role: m.Role, Content: m.Content}
+		if i < len(times) && !times[i].IsZero() {
+			t := times[i].UTC()
+			em.Time = &t
+		}
+		exp.Messages = append(exp.Messages, em)
+	}
+
+	var data []byte
+	var err error
+	var mimeType, ext string
+	switch exportFormat {
+	case exportFormatJSON:
+		data, err = json.MarshalIndent(exp, "", "  ")
+		mimeType, ext = "application/json", "json"
+	case exportFormatMarkdown, "md":
+		data = []byte(formatExportMarkdown(exp))
+		mimeType, ext = "text/markdown", "md"
+	default:
+		return b.markdownResponse(evt, true, exportUsageMsg)
+	}
+	if err != nil {
+		return err
+	}
+
+	fileName := fmt.Sprintf("conversation-%s.%s", exp.ExportedAt.Format("2006-01-02-150405"), ext)
+	content := &event.MessageEventContent{
+		MsgType:  event.MsgFile,
+		Body:     fileName,
+		FileName: fileName,
+		Info: &event.FileInfo{
+			MimeType: mimeType,
+			Size:     len(data),
+		},
+	}
+	content.SetReply(evt)
+
+	if err := b.uploadFile(evt, content, data, mimeType); err != nil {
+		return err
+	}
+
+	_, err = b.client.SendMessageEvent(evt.RoomID, event.EventMessage, content)
+	return err
+}
+
+// importResponse restores the history of the user from the JSON export the command replies to.
+func (b *Bot) importResponse(ctx context.Context, u *user, evt *event.Event, msg string) error {
+	replyTo := evt.Content.AsMessage().RelatesTo.GetReplyTo()
+	if replyTo == "" {
+		return b.markdownResponse(evt, true, exportUsageMsg)
+	}
+
+	fileEvt, err := b.client.GetEvent(evt.RoomID, replyTo)
+	if err != nil {
+		return err
+	}
+	fileEvt, err = b.decryptEvent(evt.RoomID, fileEvt)
+	if err != nil {
+		return err
+	}
+	content := fileEvt.Content.AsMessage()
+	if fileEvt.Type != event.EventMessage || content.MsgType != event.MsgFile {
+		return b.markdownResponse(evt, true, exportUsageMsg)
+	}
+	// Check the announced size first to avoid downloading large files, the actual size is checked when parsing.
+	if content.Info != nil && content.Info.Size > maxImportSize {
+		return b.markdownResponse(evt, true, "Invalid conversation export: the file is too large.")
+	}
+
+	data, err := b.downloadFile(fileEvt)
+	if err != nil {
+		return err
+	}
+
+	history, times, err := parseExport(data)
+	if err != nil {
+		return b.markdownResponse(evt, true, fmt.Sprintf("Invalid conversation export: %s.", err))
+	}
+
+	unlock, err := u.lockTurn(ctx)
+	if err != nil {
+		return err
+	}
+	u.history.restore(history, times)
+	unlock()
+
+	return b.markdownResponse(evt, true, fmt.Sprintf("Imported %d messages. The conversation continues from there.", len(history)))
+}
+
+// parseExport validates a JSON export and returns its messages as history, together with their times.
+func parseExport(data []byte) ([]openai.ChatCompletionMessage, []time.Time, error) {
+	if len(data) > maxImportSize {
+		return nil, nil, errors.New("the file is too large")
+	}
+
+	var exp conversationExport
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&exp); err != nil {
+		return nil, nil, errors.New("only JSON exports can be imported")
+	}
+
+	if exp.Version != exportVersion {
+		return nil, nil, fmt.Errorf("unsupported version %d", exp.Version)
+	}
+	if len(exp.Messages) == 0 {
+		return nil, nil, errors.New("no messages")
+	}
+
+	history := make([]openai.ChatCompletionMessage, 0, len(exp.Messages))
+	times := make([]time.Time, len(exp.Messages))
+	for i, m := range exp.Messages {
+		if m.Role != openai.ChatMessageRoleUser && m.Role != openai.ChatMessageRoleAssistant {
+			return nil, nil, fmt.Errorf("message %d has an invalid role %q", i+1, m.Role)
+		}
+		if m.Content == "" {
+			return nil, nil, fmt.Errorf("message %d is empty", i+1)
+		}
+		history = append(history, openai.ChatCompletionMessage{Role: m.Role, Content: m.Content})
+		if m.Time != nil {
+			times[i] = *m.Time
+		}
+	}
+
+	return history, times, nil
+}
+
+// formatExportMarkdown returns a readable representation of the export.
+func formatExportMarkdown(exp conversationExport) string {
+	var sb strings.Builder
+	sb.WriteString("# Conversation\n\n")
+	fmt.Fprintf(&sb, "- Exported: %s\n", exp.ExportedAt.Format(time.RFC3339))
+	if !exp.LastMessageAt.IsZero() {
+		fmt.Fprintf(&sb, "- Last message: %s\n", exp.LastMessageAt.Format(time.RFC3339))
+	}
+	fmt.Fprintf(&sb, "- Model: %s\n", exp.Model)
+	if exp.SystemPrompt != "" {
+		fmt.Fprintf(&sb, "- System prompt: %s\n", exp.SystemPrompt)
+	}
+
+	for _, m := range exp.Messages {
+		role := "User"
+		if m.Role == openai.ChatMessageRoleAssistant {
+			role = "Assistant"
+		}
+		if m.Time != nil {
+			role += " (" + m.Time.Format(time.RFC3339) + ")"
+		}
+		fmt.Fprintf(&sb, "\n## %s\n\n%s\n", role, m.Content)
+	}
+
+	return sb.String()
+}
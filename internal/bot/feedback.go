@@ -0,0 +1,202 @@
+package bot
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/mazzz1y/matrix-gpt/internal/store"
+	"github.com/rs/zerolog/log"
+	"github.com/sashabaranov/go-openai"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+const (
+	ratingPositive = 1
+	ratingNegative = -1
+)
+
+// feedbackRecord is a line of the JSONL feedback export.
+type feedbackRecord struct {
+	Rating    string    `json:"rating"`
+	Prompt    string    `json:"prompt"`
+	Answer    string    `json:"answer"`
+	Model     string    `json:"model"`
+	Persona   string    `json:"persona,omitempty"`
+	EventID   string    `json:"event_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ExportFeedback writes the feedback stored in the database as JSONL.
+func ExportFeedback(ctx context.Context, cfg Config, w io.Writer) error {
+	st, err := store.New(cfg.SQLitePath)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	feedback, err := st.GetFeedback(ctx)
+	if err != nil {
+		return err
+	}
+
+	return writeFeedbackJSONL(w, feedback)
+}
+
+// recordAnswer stores the last turn of the history as the answer sent with the event, together with
+// the model and the persona of the room, so that it can still be rated once it left the history.
+// Errors are only logged, since the answer was already sent.
+func (b *Bot) recordAnswer(ctx context.Context, evt *event.Event, answerID id.EventID, history []openai.ChatCompletionMessage) {
+	if len(history) < 2 {
+		return
+	}
+
+	settings := b.getRoomSettings(evt.RoomID)
+	model := b.roomGeneration(settings).Model
+	if model == "" {
+		model = b.gptClient.GetModel()
+	}
+
+	err := b.store.PutAnswer(ctx, store.Answer{
+		EventID:   answerID.String(),
+		UserID:    evt.Sender.String(),
+		RoomID:    evt.RoomID.String(),
+		Prompt:    history[len(history)-2].Content,
+		Answer:    history[len(history)-1].Content,
+		Model:     model,
+		Persona:   settings.Persona,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		log.Err(err).Str("event-id", answerID.String()).Msg("failed to record answer")
+	}
+}
+
+// recordFeedback stores the rating of the answer the reaction relates to, if it was sent to the reacting user.
+func (b *Bot) recordFeedback(ctx context.Context, reaction *event.Event, rating int) error {
+	answerID := reaction.Content.AsReaction().RelatesTo.EventID
+	a, err := b.store.GetAnswer(ctx, answerID.String())
+	if err != nil || a == nil || a.UserID != reaction.Sender.String() {
+		return err
+	}
+
+	return b.store.PutFeedback(ctx, store.Feedback{
+		UserID:    a.UserID,
+		RoomID:    a.RoomID,
+		EventID:   a.EventID,
+		Rating:    rating,
+		Prompt:    a.Prompt,
+		Answer:    a.Answer,
+		Model:     a.Model,
+		Persona:   a.Persona,
+		CreatedAt: time.Now(),
+	})
+}
+
+// pruneAnswers deletes the recorded answers older than the retention period. Answers are kept forever if it is 0.
+func (b *Bot) pruneAnswers(ctx context.Context) {
+	if b.answerRetention <= 0 {
+		return
+	}
+
+	n, err := b.store.DeleteAnswersBefore(ctx, time.Now().Add(-b.answerRetention))
+	if err != nil {
+		log.Err(err).Msg("answer pruning error")
+		return
+	}
+	if n > 0 {
+		log.Debug().Int64("answers", n).Msg("old answers deleted")
+	}
+}
+
+// feedbackResponse shows the number of ratings, or uploads them as a JSONL file with `!feedback export`.
+// It is only available to admins.
+func (b *Bot) feedbackResponse(ctx context.Context, u *user, evt *event.Event, msg string) error {
+	if !b.isAdmin(evt.Sender.String()) {
+		return b.markdownResponse(evt, true, adminOnlyMsg)
+	}
+
+	feedback, err := b.store.GetFeedback(ctx)
+	if err != nil {
+		return err
+	}
+
+	switch msg {
+	case "":
+		var positive, negative int
+		for _, f := range feedback {
+			if f.Rating > 0 {
+				positive++
+			} else {
+				negative++
+			}
+		}
+		return b.markdownResponse(evt, true, fmt.Sprintf("Feedback: %d 👍, %d 👎. Use `!feedback export` to download it.", positive, negative))
+	case "export":
+	default:
+		return b.markdownResponse(evt, true, "Usage: `!feedback` or `!feedback export`.")
+	}
+
+	var buf bytes.Buffer
+	if err := writeFeedbackJSONL(&buf, feedback); err != nil {
+		return err
+	}
+
+	fileName := fmt.Sprintf("feedback-%s.jsonl", time.Now().UTC().Format("2006-01-02-150405"))
+	content := &event.MessageEventContent{
+		MsgType:  event.MsgFile,
+		Body:     fileName,
+		FileName: fileName,
+		Info: &event.FileInfo{
+			MimeType: "application/jsonl",
+			Size:     buf.Len(),
+		},
+	}
+	content.SetReply(evt)
+
+	if err := b.uploadFile(evt, content, buf.Bytes(), content.Info.MimeType); err != nil {
+		return err
+	}
+
+	_, err = b.client.SendMessageEvent(evt.RoomID, event.EventMessage, content)
+	return err
+}
+
+// isAdmin reports whether the user is a bot admin.
+func (b *Bot) isAdmin(userID string) bool {
+	for _, a := range b.adminIDs {
+		if a == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// writeFeedbackJSONL writes the feedback as one JSON object per line.
+func writeFeedbackJSONL(w io.Writer, feedback []store.Feedback) error {
+	enc := json.NewEncoder(w)
+	for _, f := range feedback {
+		rating := "positive"
+		if f.Rating < 0 {
+			rating = "negative"
+		}
+
+		err := enc.Encode(feedbackRecord{
+			Rating:    rating,
+			Prompt:    f.Prompt,
+			Answer:    f.Answer,
+			Model:     f.Model,
+			Persona:   f.Persona,
+			EventID:   f.EventID,
+			CreatedAt: f.CreatedAt.UTC(),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
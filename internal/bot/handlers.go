@@ -11,24 +11,52 @@ import (
 	"maunium.net/go/mautrix/event"
 )
 
-// joinRoomHandler handles when the bot is invited to a room.
+// joinRoomHandler handles membership changes. It accepts invites from allowed users
+// and leaves rooms that don't match the invite policy anymore when other members join or leave.
 func (b *Bot) joinRoomHandler(source mautrix.EventSource, evt *event.Event) {
 	userID := evt.Sender.String()
 	l := log.With().
 		Str("event", "join-room").
 		Str("user-id", userID).
 		Logger()
+
+	if evt.GetStateKey() != b.client.UserID.String() {
+		// Only joins and leaves in the timeline change the members, not the room state sent with the sync
+		// or profile updates.
+		if source&mautrix.EventSourceTimeline == 0 || !isJoinOrLeave(evt) {
+			return
+		}
+		if b.client.StateStore != nil && !b.client.StateStore.IsMembership(evt.RoomID, b.client.UserID, event.MembershipJoin) {
+			return
+		}
+		if _, err := b.checkRoomMembers(evt.RoomID); err != nil {
+			l.Err(err).Msg("room members check error")
+		}
+		return
+	}
+
 	_, ok := b.users[userID]
-	if ok &&
-		evt.GetStateKey() == b.client.UserID.String() &&
-		evt.Content.AsMember().Membership == event.MembershipInvite {
-		_, err := b.client.JoinRoomByID(evt.RoomID)
-		if err != nil {
+	if ok && evt.Content.AsMember().Membership == event.MembershipInvite {
+		if err := b.acceptInvite(evt); err != nil {
 			l.Err(err).Msg("join room error")
 		}
 	}
 }
 
+// isJoinOrLeave reports whether the member event is a transition into or out of the joined state.
+func isJoinOrLeave(evt *event.Event) bool {
+	prev := event.MembershipLeave
+	if evt.Unsigned.PrevContent != nil {
+		if err := evt.Unsigned.PrevContent.ParseRaw(evt.Type); err != nil && !errors.Is(err, event.ErrContentAlreadyParsed) {
+			return true
+		}
+		prev = evt.Unsigned.PrevContent.AsMember().Membership
+	}
+
+	membership := evt.Content.AsMember().Membership
+	return membership != prev && (membership == event.MembershipJoin || prev == event.MembershipJoin)
+}
+
 // redactionHandler handles when a previous message is redacted (deleted).
 func (b *Bot) redactionHandler(source mautrix.EventSource, evt *event.Event) {
 	userID := evt.Sender.String()
@@ -43,9 +71,7 @@ func (b *Bot) redactionHandler(source mautrix.EventSource, evt *event.Event) {
 		return
 	}
 
-	reqID, ok := user.getActiveRequestID()
-	if ok && reqID == evt.Redacts.String() {
-		user.cancelRequestContext(reqID)
+	if user.cancelRequestContext(evt.Redacts.String()) {
 		l.Debug().Msg("request cancelled")
 	}
 }
@@ -57,6 +83,18 @@ func (b *Bot) messageHandler(source mautrix.EventSource, evt *event.Event) {
 		return
 	}
 
+	// Rooms with a language translate the messages of all members, not only of allowed users.
+	unencrypted := b.requireEncryption && !b.isEncrypted(evt)
+	settings := b.getRoomSettings(evt.RoomID)
+	if !unencrypted && settings.Language != "" && extractCommand(commandBody(evt.Content.AsMessage())) == "" {
+		b.autoTranslate(evt, settings.Language)
+	}
+
+	if b.stopping.Load() {
+		log.Debug().Str("user-id", userID).Msg("shutting down, message ignored")
+		return
+	}
+
 	l := log.With().
 		Str("event", "message").
 		Str("user-id", userID).
@@ -67,20 +105,40 @@ func (b *Bot) messageHandler(source mautrix.EventSource, evt *event.Event) {
 		l.Debug().Msg("forbidden")
 		return
 	}
-	l.Debug().Msg("received request, processing")
 
-	histExpired := user.getLastMsgTime().Add(b.historyExpire).Before(time.Now())
-	histSize := user.history.getSize()
-	if histExpired && histSize != 0 {
-		l.Debug().Msg("history expired, resetting before processing")
-		user.history.reset()
+	if evt.Content.AsMessage().MsgType == event.MsgVerificationRequest {
+		b.verificationHandler(source, evt)
+		return
+	}
+
+	if !b.isTriggered(evt, settings) {
+		l.Debug().Msg("not mentioned, message ignored")
+		return
+	}
+
+	if unencrypted {
+		l.Debug().Msg("unencrypted room, message ignored")
+		_ = b.markdownResponse(evt, true, unencryptedMsg)
+		return
 	}
 
+	l.Debug().Msg("received request, processing")
+
+	if !b.beginRequest() {
+		l.Debug().Msg("shutting down, message ignored")
+		return
+	}
 	go func() {
+		defer b.inFlight.Done()
+
 		evtID := evt.ID.String()
-		ctx := user.createRequestContext(evtID)
+		ctx := user.createRequestContext(b.reqCtx, evtID)
 		defer user.cancelRequestContext(evtID)
 
+		if b.expireHistory(*ctx, user) {
+			l.Debug().Msg("history expired, reset before processing")
+		}
+
 		err := b.sendResponse(*ctx, user, evt)
 		if err == context.Canceled {
 			return
@@ -96,15 +154,36 @@ func (b *Bot) messageHandler(source mautrix.EventSource, evt *event.Event) {
 	}()
 }
 
+// expireHistory resets the history of the user if the last message is older than the history expiration.
+// It waits for the turns in progress, so they don't add to the history after it was reset.
+func (b *Bot) expireHistory(ctx context.Context, u *user) bool {
+	unlock, err := u.lockTurn(ctx)
+	if err != nil {
+		return false
+	}
+	defer unlock()
+
+	if u.history.getSize() == 0 || !u.getLastMsgTime().Add(b.historyExpire).Before(time.Now()) {
+		return false
+	}
+	u.resetHistory()
+	return true
+}
+
 // sendResponse responds to the user command.
 func (b *Bot) sendResponse(ctx context.Context, u *user, e *event.Event) (err error) {
-	u.reqMutex.Lock()
 	b.markRead(e)
+
+	release, err := b.acquireSlot(ctx, u, e)
+	if err != nil {
+		return err
+	}
+	defer release()
+
 	b.startTyping(e.RoomID)
 	defer b.stopTyping(e.RoomID)
-	defer u.reqMutex.Unlock()
 
-	body := e.Content.AsMessage().Body
+	body := commandBody(e.Content.AsMessage())
 	cmd := extractCommand(body)
 	msg := trimCommand(body)
 
@@ -121,8 +200,12 @@ func (b *Bot) err(evt *event.Event, err error) {
 	switch t := err.(type) {
 	case *unknownCommandError:
 		b.markdownResponse(evt, true, unknownCommandMsg)
+	case *ambiguousCommandError:
+		b.markdownResponse(evt, true, t.Error())
 	case *openai.APIError:
 		b.markdownResponse(evt, true, t.Message)
+	case *moderationError:
+		b.reactionResponse(evt, blockedReaction)
 	default:
 		if errors.Is(err, context.DeadlineExceeded) {
 			b.markdownResponse(evt, true, timeoutMsg)
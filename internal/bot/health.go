@@ -0,0 +1,169 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"maunium.net/go/mautrix"
+)
+
+const (
+	// syncStaleAfter is the time without a successful sync after which the bot is considered wedged.
+	syncStaleAfter = 5 * time.Minute
+	// gptCheckInterval is how long the result of an OpenAI reachability check is cached.
+	gptCheckInterval = time.Minute
+)
+
+// health keeps track of the bot state reported by the health endpoints.
+type health struct {
+	sync.RWMutex
+	cryptoReady bool
+	startedAt   time.Time
+	lastSync    time.Time
+	gptChecked  time.Time
+	gptErr      error
+
+	// appservice is set if events are pushed by the homeserver, so there is no sync to monitor.
+	appservice bool
+}
+
+// healthStatus is the JSON body returned by the health endpoints.
+type healthStatus struct {
+	Status      string     `json:"status"`
+	CryptoReady bool       `json:"crypto_ready"`
+	Appservice  bool       `json:"appservice,omitempty"`
+	LastSync    *time.Time `json:"last_sync,omitempty"`
+	OpenAI      string     `json:"openai,omitempty"`
+}
+
+// setCryptoReady records whether the crypto helper was initialized successfully.
+func (h *health) setCryptoReady(ready bool) {
+	h.Lock()
+	defer h.Unlock()
+
+	h.cryptoReady = ready
+}
+
+// start records the time the sync loop was started.
+func (h *health) start() {
+	h.Lock()
+	defer h.Unlock()
+
+	h.startedAt = time.Now()
+}
+
+// syncHandler records the time of the last successful sync. It is registered as a syncer callback.
+func (h *health) syncHandler(_ *mautrix.RespSync, _ string) bool {
+	h.Lock()
+	defer h.Unlock()
+
+	h.lastSync = time.Now()
+	return true
+}
+
+// isSyncAlive reports whether the sync loop made progress recently.
+// Before the first sync completes, the start time is used as a reference.
+// In appservice mode, it only reports whether the appservice server was started.
+func (h *health) isSyncAlive() bool {
+	h.RLock()
+	defer h.RUnlock()
+
+	if h.appservice {
+		return !h.startedAt.IsZero()
+	}
+
+	ref := h.lastSync
+	if ref.IsZero() {
+		ref = h.startedAt
+	}
+
+	return !ref.IsZero() && time.Since(ref) < syncStaleAfter
+}
+
+// checkGpt returns the result of the last OpenAI reachability check, refreshing it if it is outdated.
+func (h *health) checkGpt(ctx context.Context, ping func(context.Context) error) error {
+	h.RLock()
+	fresh := time.Since(h.gptChecked) < gptCheckInterval
+	err := h.gptErr
+	h.RUnlock()
+	if fresh {
+		return err
+	}
+
+	err = ping(ctx)
+
+	h.Lock()
+	defer h.Unlock()
+	h.gptChecked = time.Now()
+	h.gptErr = err
+
+	return err
+}
+
+// status builds the current health status without checking external services.
+func (h *health) status() healthStatus {
+	h.RLock()
+	defer h.RUnlock()
+
+	s := healthStatus{CryptoReady: h.cryptoReady, Appservice: h.appservice}
+	if !h.lastSync.IsZero() {
+		lastSync := h.lastSync
+		s.LastSync = &lastSync
+	}
+
+	return s
+}
+
+// HTTPHandler returns an HTTP handler serving the /healthz and /readyz endpoints,
+// and the /v1/rooms/ API if API tokens are configured.
+func (b *Bot) HTTPHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", b.healthzHandler)
+	mux.HandleFunc("/readyz", b.readyzHandler)
+	if len(b.apiTokens) > 0 {
+		mux.HandleFunc("/v1/rooms/", b.apiHandler)
+	}
+	return mux
+}
+
+// healthzHandler reports whether the bot is alive, i.e. crypto is initialized and sync is not stalled.
+// Appservices have no crypto, so only the server state is checked.
+func (b *Bot) healthzHandler(w http.ResponseWriter, r *http.Request) {
+	s := b.health.status()
+	ok := (s.CryptoReady || s.Appservice) && b.health.isSyncAlive()
+
+	writeHealthStatus(w, s, ok)
+}
+
+// readyzHandler reports whether the bot is ready to serve requests,
+// i.e. it is alive, has completed at least one sync and OpenAI is reachable.
+func (b *Bot) readyzHandler(w http.ResponseWriter, r *http.Request) {
+	s := b.health.status()
+	ok := ((s.CryptoReady && s.LastSync != nil) || s.Appservice) && b.health.isSyncAlive()
+
+	if err := b.health.checkGpt(r.Context(), b.gptClient.Ping); err != nil {
+		s.OpenAI = err.Error()
+		ok = false
+	} else {
+		s.OpenAI = "ok"
+	}
+
+	writeHealthStatus(w, s, ok)
+}
+
+// writeHealthStatus writes the health status as JSON with a status code matching its state.
+func writeHealthStatus(w http.ResponseWriter, s healthStatus, ok bool) {
+	code := http.StatusOK
+	s.Status = "ok"
+	if !ok {
+		code = http.StatusServiceUnavailable
+		s.Status = "unavailable"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(s)
+}
@@ -2,15 +2,24 @@ package bot
 
 import (
 	"sync"
+	"time"
 
 	"github.com/sashabaranov/go-openai"
+	"maunium.net/go/mautrix/id"
 )
 
 // historyManager manages chat histories for GPT interactions.
 type historyManager struct {
 	sync.RWMutex
 	storage []openai.ChatCompletionMessage
-	maxSize int
+	// eventIDs holds the Matrix event of each history entry, empty for entries restored from a file or snapshot.
+	eventIDs []id.EventID
+	// times holds the time each history entry was added, zero if unknown.
+	times []time.Time
+	// generation counts the replacements of the history by a reset, a load or an import, so turns started
+	// before are not added to the new history.
+	generation int
+	maxSize    int
 }
 
 // newHistoryManager initializes a HistoryManager instance with the provided size.
@@ -26,20 +35,115 @@ func (m *historyManager) reset() {
 	m.Lock()
 	defer m.Unlock()
 
+	m.generation++
 	if len(m.storage) > 0 {
 		m.storage = make([]openai.ChatCompletionMessage, 0)
+		m.eventIDs = nil
+		m.times = nil
 	}
 }
 
-// save keeps the last 'm.Size' messages in memory.
+// save replaces the history, keeping the last 'm.Size' messages in memory.
 func (m *historyManager) save(h []openai.ChatCompletionMessage) {
 	m.Lock()
 	defer m.Unlock()
 
+	m.generation++
+	m.store(h, make([]id.EventID, len(h)), make([]time.Time, len(h)))
+}
+
+// restore replaces the history with entries added at the given times, e.g. from an export.
+func (m *historyManager) restore(h []openai.ChatCompletionMessage, times []time.Time) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.generation++
+	m.store(h, make([]id.EventID, len(h)), times)
+}
+
+// saveTurn saves a history ending with a new user message and answer, recording their Matrix events.
+// The entries before them must be a suffix of the current history.
+func (m *historyManager) saveTurn(h []openai.ChatCompletionMessage, userEvtID, answerEvtID id.EventID) {
+	m.Lock()
+	defer m.Unlock()
+
+	ids := make([]id.EventID, len(h)-2, len(h))
+	times := make([]time.Time, len(h)-2, len(h))
+	if prev := len(h) - 2; prev <= len(m.eventIDs) {
+		copy(ids, m.eventIDs[len(m.eventIDs)-prev:])
+		copy(times, m.times[len(m.times)-prev:])
+	}
+
+	now := time.Now()
+	m.store(h, append(ids, userEvtID, answerEvtID), append(times, now, now))
+}
+
+// appendTurn adds the entries of a new turn, ending with the user message and the answer, recording their Matrix
+// events. It reports false without adding them if the history was replaced since the given generation.
+func (m *historyManager) appendTurn(generation int, turn []openai.ChatCompletionMessage, userEvtID, answerEvtID id.EventID) bool {
+	m.Lock()
+	defer m.Unlock()
+
+	if generation != m.generation {
+		return false
+	}
+
+	n := len(m.storage)
+	h := append(m.storage[:n:n], turn...)
+	ids := append(m.eventIDs[:n:n], make([]id.EventID, len(turn)-2)...)
+	times := append(m.times[:n:n], make([]time.Time, len(turn)-2)...)
+
+	now := time.Now()
+	m.store(h, append(ids, userEvtID, answerEvtID), append(times, now, now))
+	return true
+}
+
+// store sets the history, the events and the times of its entries, keeping the last 'm.Size' of them.
+func (m *historyManager) store(h []openai.ChatCompletionMessage, ids []id.EventID, times []time.Time) {
 	if m.maxSize != 0 && len(h) > m.maxSize {
-		m.storage = h[len(h)-m.maxSize:]
-	} else {
-		m.storage = h
+		h = h[len(h)-m.maxSize:]
+		ids = ids[len(ids)-m.maxSize:]
+		times = times[len(times)-m.maxSize:]
+	}
+
+	m.storage = h
+	m.eventIDs = ids
+	m.times = times
+}
+
+// position returns the index of the history entry of the Matrix event, or -1 if it is not in the history.
+func (m *historyManager) position(evtID id.EventID) int {
+	m.RLock()
+	defer m.RUnlock()
+
+	for i, e := range m.eventIDs {
+		if e == evtID {
+			return i
+		}
+	}
+	return -1
+}
+
+// eventID returns the Matrix event of the history entry.
+func (m *historyManager) eventID(i int) id.EventID {
+	m.RLock()
+	defer m.RUnlock()
+
+	if i < 0 || i >= len(m.eventIDs) {
+		return ""
+	}
+	return m.eventIDs[i]
+}
+
+// truncate keeps the first n history entries.
+func (m *historyManager) truncate(n int) {
+	m.Lock()
+	defer m.Unlock()
+
+	if n < len(m.storage) {
+		m.storage = append([]openai.ChatCompletionMessage(nil), m.storage[:n]...)
+		m.eventIDs = append([]id.EventID(nil), m.eventIDs[:n]...)
+		m.times = append([]time.Time(nil), m.times[:n]...)
 	}
 }
 
@@ -51,6 +155,22 @@ func (m *historyManager) get() []openai.ChatCompletionMessage {
 	return m.storage
 }
 
+// snapshot retrieves the current chat history and its generation.
+func (m *historyManager) snapshot() ([]openai.ChatCompletionMessage, int) {
+	m.RLock()
+	defer m.RUnlock()
+
+	return m.storage, m.generation
+}
+
+// getWithTimes retrieves the current chat history and the times its entries were added.
+func (m *historyManager) getWithTimes() ([]openai.ChatCompletionMessage, []time.Time) {
+	m.RLock()
+	defer m.RUnlock()
+
+	return m.storage, m.times
+}
+
 // getSize retrieves the current history size.
 func (m *historyManager) getSize() int {
 	m.RLock()
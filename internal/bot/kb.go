@@ -0,0 +1,191 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/mazzz1y/matrix-gpt/internal/gpt"
+	"github.com/mazzz1y/matrix-gpt/internal/kb"
+	"github.com/mazzz1y/matrix-gpt/internal/redact"
+	"github.com/mazzz1y/matrix-gpt/internal/store"
+	"github.com/rs/zerolog/log"
+	"maunium.net/go/mautrix/event"
+)
+
+// maxKBDocumentSize is the maximum size of a document added with `!kb add` in bytes.
+const maxKBDocumentSize = 1 << 20
+
+const kbPrompt = "Answer using the following excerpts of the internal knowledge base if they are relevant to the question. " +
+	"Refer to the excerpts you used by their number, e.g. [1]. If they are not relevant, answer as usual.\n\n"
+
+// IngestDocuments adds the text files to the knowledge base in the database. The file name is used as the source
+// name, so ingesting a file again replaces its previous version. The chunks are redacted for their embeddings
+// if redaction is enabled.
+func IngestDocuments(ctx context.Context, cfg Config, g *gpt.Gpt, paths []string) error {
+	redactor, err := newRedactor(cfg)
+	if err != nil {
+		return err
+	}
+
+	st, err := store.New(cfg.SQLitePath)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	k := kb.New(st, redactingEmbedder{embedder: g, redactor: redactor})
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if !utf8.Valid(data) {
+			return fmt.Errorf("%s is not a text file", path)
+		}
+
+		n, err := k.Ingest(ctx, filepath.Base(path), string(data))
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		log.Info().Str("path", path).Int("chunks", n).Msg("document ingested")
+	}
+
+	return nil
+}
+
+// kbResponse lists, adds or deletes knowledge base documents.
+// Usage: `!kb`, `!kb add [name]` as a reply to a text file, or `!kb delete <name>`.
+// The knowledge base is shared by all rooms, so only admins may add or delete documents.
+func (b *Bot) kbResponse(ctx context.Context, u *user, evt *event.Event, msg string) error {
+	cmd, arg, _ := strings.Cut(msg, " ")
+	arg = strings.TrimSpace(arg)
+
+	if (cmd == "add" || cmd == "delete") && !b.isAdmin(evt.Sender.String()) {
+		return b.markdownResponse(evt, true, adminOnlyMsg)
+	}
+
+	switch {
+	case cmd == "" || cmd == "list":
+		return b.listKBSources(ctx, evt)
+	case cmd == "add":
+		return b.addKBSource(ctx, evt, arg)
+	case cmd == "delete" && arg != "":
+		ok, err := b.kb.Delete(ctx, arg)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return b.markdownResponse(evt, true, fmt.Sprintf("Document `%s` not found.", arg))
+		}
+		b.reactionResponse(evt, "✅")
+		return nil
+	default:
+		return b.markdownResponse(evt, true, kbUsageMsg)
+	}
+}
+
+// listKBSources responds with the documents of the knowledge base.
+func (b *Bot) listKBSources(ctx context.Context, evt *event.Event) error {
+	sources, err := b.kb.Sources(ctx)
+	if err != nil {
+		return err
+	}
+	if len(sources) == 0 {
+		return b.markdownResponse(evt, true, "The knowledge base is empty. "+kbUsageMsg)
+	}
+
+	var sb strings.Builder
+	sb.WriteString("**Knowledge base**\n")
+	for _, src := range sources {
+		fmt.Fprintf(&sb, "- `%s` (%d chunks)\n", src.Name, src.Chunks)
+	}
+
+	return b.markdownResponse(evt, false, sb.String())
+}
+
+// addKBSource ingests the text file the command replies to.
+func (b *Bot) addKBSource(ctx context.Context, evt *event.Event, name string) error {
+	replyTo := evt.Content.AsMessage().RelatesTo.GetReplyTo()
+	if replyTo == "" {
+		return b.markdownResponse(evt, true, kbUsageMsg)
+	}
+
+	fileEvt, err := b.client.GetEvent(evt.RoomID, replyTo)
+	if err != nil {
+		return err
+	}
+	fileEvt, err = b.decryptEvent(evt.RoomID, fileEvt)
+	if err != nil {
+		return err
+	}
+	content := fileEvt.Content.AsMessage()
+	if fileEvt.Type != event.EventMessage || content.MsgType != event.MsgFile {
+		return b.markdownResponse(evt, true, kbUsageMsg)
+	}
+	// Check the announced size first to avoid downloading large files, it may be missing or wrong.
+	if content.Info != nil && content.Info.Size > maxKBDocumentSize {
+		return b.markdownResponse(evt, true, kbTooLargeMsg)
+	}
+
+	data, err := b.downloadFile(fileEvt)
+	if err != nil {
+		return err
+	}
+	if len(data) > maxKBDocumentSize {
+		return b.markdownResponse(evt, true, kbTooLargeMsg)
+	}
+	if !utf8.Valid(data) {
+		return b.markdownResponse(evt, true, "Only text files can be added to the knowledge base.")
+	}
+
+	if name == "" {
+		name = content.FileName
+		if name == "" {
+			name = content.Body
+		}
+	}
+
+	n, err := b.kb.Ingest(ctx, name, string(data))
+	if err != nil {
+		return err
+	}
+
+	return b.markdownResponse(evt, true, fmt.Sprintf("Added `%s` to the knowledge base (%d chunks).", name, n))
+}
+
+// retrieveKnowledge searches the knowledge base for the message. It returns the system prompt extended with the
+// found excerpts and the list of their sources to append to the answer, or the unchanged prompt if nothing was found.
+// The excerpts are redacted with the placeholders of the message.
+func (b *Bot) retrieveKnowledge(ctx context.Context, placeholders *redact.Mapping, systemPrompt, msg string) (string, string) {
+	results, err := b.kb.Search(ctx, msg, b.kbTopK, b.kbMinScore)
+	if err != nil {
+		if !errors.Is(err, context.Canceled) {
+			log.Err(err).Msg("knowledge base search error")
+		}
+		return systemPrompt, ""
+	}
+	if len(results) == 0 {
+		return systemPrompt, ""
+	}
+
+	var prompt, sources strings.Builder
+	if systemPrompt != "" {
+		prompt.WriteString(systemPrompt + "\n\n")
+	}
+	prompt.WriteString(kbPrompt)
+	sources.WriteString("\n\n**Sources:**")
+	for i, r := range results {
+		prompt.WriteString(b.redactor.Redact(fmt.Sprintf("[%d] %s\n%s\n\n", i+1, r.Source, r.Content), placeholders))
+		fmt.Fprintf(&sources, " [%d] `%s` (part %d)", i+1, r.Source, r.Index+1)
+		if i < len(results)-1 {
+			sources.WriteString(",")
+		}
+	}
+
+	return prompt.String(), sources.String()
+}
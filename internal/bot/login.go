@@ -0,0 +1,103 @@
+package bot
+
+import (
+	"context"
+	"errors"
+
+	"github.com/mazzz1y/matrix-gpt/internal/store"
+	"github.com/rs/zerolog/log"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/crypto/cryptohelper"
+	"maunium.net/go/mautrix/id"
+)
+
+var errNoCredentials = errors.New("no matrix credentials: set an access token, a password or run the login command")
+
+// configureLogin sets up the client credentials in order of precedence:
+// an access token from the config, a session saved in the store, or a password login performed by the crypto helper.
+// It reports whether a password login is pending, in which case the session must be saved after the crypto init.
+func configureLogin(ctx context.Context, client *mautrix.Client, crypto *cryptohelper.CryptoHelper, st *store.Store, cfg Config) (bool, error) {
+	if cfg.AccessToken != "" {
+		log.Debug().Msg("using access token from config")
+		return false, setCredentials(client, cfg.UserID, cfg.DeviceID, cfg.AccessToken)
+	}
+
+	sess, err := st.GetSession(ctx, cfg.UserID)
+	if err != nil {
+		return false, err
+	}
+	if sess != nil {
+		log.Debug().Msg("using stored session")
+		return false, setCredentials(client, sess.UserID, sess.DeviceID, sess.AccessToken)
+	}
+
+	if cfg.Password == "" {
+		return false, errNoCredentials
+	}
+
+	crypto.LoginAs = &mautrix.ReqLogin{
+		Type:       mautrix.AuthTypePassword,
+		Identifier: mautrix.UserIdentifier{Type: mautrix.IdentifierTypeUser, User: cfg.UserID},
+		Password:   cfg.Password,
+	}
+
+	return true, nil
+}
+
+// setCredentials sets the client credentials, looking up the device ID on the server if it is not provided.
+func setCredentials(client *mautrix.Client, userID, deviceID, accessToken string) error {
+	client.SetCredentials(id.UserID(userID), accessToken)
+	client.DeviceID = id.DeviceID(deviceID)
+	if deviceID != "" {
+		return nil
+	}
+
+	resp, err := client.Whoami()
+	if err != nil {
+		return err
+	}
+
+	client.DeviceID = resp.DeviceID
+	return nil
+}
+
+// saveSession stores the current client credentials, so that following starts do not need a password.
+func saveSession(ctx context.Context, client *mautrix.Client, st *store.Store) error {
+	return st.PutSession(ctx, store.Session{
+		UserID:      client.UserID.String(),
+		DeviceID:    client.DeviceID.String(),
+		AccessToken: client.AccessToken,
+	})
+}
+
+// Login performs a one-off login with the given request and stores the session in the SQLite database.
+// If the database already has a crypto account, its device ID is reused to keep the encryption keys valid.
+func Login(ctx context.Context, serverURL, sqlitePath string, req *mautrix.ReqLogin) (*store.Session, error) {
+	st, err := store.New(sqlitePath)
+	if err != nil {
+		return nil, err
+	}
+	defer st.Close()
+
+	client, err := mautrix.NewClient(serverURL, "", "")
+	if err != nil {
+		return nil, err
+	}
+
+	deviceID, err := st.GetCryptoDeviceID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	req.DeviceID = id.DeviceID(deviceID)
+	req.StoreCredentials = true
+	if _, err := client.Login(req); err != nil {
+		return nil, err
+	}
+
+	if err := saveSession(ctx, client, st); err != nil {
+		return nil, err
+	}
+
+	return st.GetSession(ctx, client.UserID.String())
+}
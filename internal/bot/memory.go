@@ -0,0 +1,140 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"maunium.net/go/mautrix/event"
+)
+
+const (
+	// maxMemories is the maximum number of facts remembered per user.
+	maxMemories = 50
+	// maxMemoryLength is the maximum length of a fact in bytes.
+	maxMemoryLength = 500
+)
+
+const memoryPrompt = "Facts the user asked you to remember:\n"
+
+var errTooManyMemories = fmt.Errorf("you can't store more than %d memories, use `!forget` to remove some", maxMemories)
+
+// rememberResponse saves a fact about the user, e.g. `!remember I work on the payments team`.
+func (b *Bot) rememberResponse(ctx context.Context, u *user, evt *event.Event, msg string) error {
+	if msg == "" {
+		return b.markdownResponse(evt, true, memoryUsageMsg)
+	}
+
+	if err := b.saveMemory(ctx, evt.Sender.String(), msg); err != nil {
+		if errors.Is(err, errTooManyMemories) {
+			return b.markdownResponse(evt, true, err.Error())
+		}
+		return err
+	}
+
+	b.reactionResponse(evt, "✅")
+	return nil
+}
+
+// forgetResponse deletes a fact about the user, or all of them with `!forget all`.
+func (b *Bot) forgetResponse(ctx context.Context, u *user, evt *event.Event, msg string) error {
+	userID := evt.Sender.String()
+	if msg == "all" {
+		if _, err := b.store.DeleteMemories(ctx, userID); err != nil {
+			return err
+		}
+		b.reactionResponse(evt, "✅")
+		return nil
+	}
+
+	memoryID, err := strconv.ParseInt(strings.TrimPrefix(msg, "#"), 10, 64)
+	if err != nil {
+		return b.markdownResponse(evt, true, memoryUsageMsg)
+	}
+
+	ok, err := b.store.DeleteMemory(ctx, memoryID, userID)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return b.markdownResponse(evt, true, fmt.Sprintf("Memory `#%d` not found.", memoryID))
+	}
+
+	b.reactionResponse(evt, "✅")
+	return nil
+}
+
+// memoriesResponse lists the facts about the user.
+func (b *Bot) memoriesResponse(ctx context.Context, u *user, evt *event.Event, msg string) error {
+	memories, err := b.store.GetMemories(ctx, evt.Sender.String())
+	if err != nil {
+		return err
+	}
+	if len(memories) == 0 {
+		return b.markdownResponse(evt, true, "I don't remember anything about you. "+memoryUsageMsg)
+	}
+
+	var sb strings.Builder
+	sb.WriteString("**Memories**\n")
+	for _, m := range memories {
+		fmt.Fprintf(&sb, "- `#%d` %s\n", m.ID, m.Fact)
+	}
+
+	return b.markdownResponse(evt, false, sb.String())
+}
+
+// saveMemory stores a fact about the user, enforcing the limits.
+func (b *Bot) saveMemory(ctx context.Context, userID, fact string) error {
+	if len(fact) > maxMemoryLength {
+		return fmt.Errorf("the fact is longer than %d characters", maxMemoryLength)
+	}
+
+	memories, err := b.store.GetMemories(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if len(memories) >= maxMemories {
+		return errTooManyMemories
+	}
+
+	_, err = b.store.AddMemory(ctx, userID, fact)
+	return err
+}
+
+// withMemories returns the system prompt followed by the facts remembered about the user.
+func (b *Bot) withMemories(ctx context.Context, userID, systemPrompt string) string {
+	memories, err := b.store.GetMemories(ctx, userID)
+	if err != nil {
+		log.Err(err).Str("user-id", userID).Msg("memories error")
+		return systemPrompt
+	}
+	if len(memories) == 0 {
+		return systemPrompt
+	}
+
+	var sb strings.Builder
+	if systemPrompt != "" {
+		sb.WriteString(systemPrompt + "\n\n")
+	}
+	sb.WriteString(memoryPrompt)
+	for _, m := range memories {
+		sb.WriteString("- " + m.Fact + "\n")
+	}
+
+	return sb.String()
+}
+
+// memoryTool returns the function saving facts on behalf of the model, or nil if the tool is disabled.
+func (b *Bot) memoryTool(ctx context.Context, userID string) func(string) error {
+	if !b.memoryToolEnabled {
+		return nil
+	}
+
+	return func(fact string) error {
+		log.Debug().Str("user-id", userID).Msg("memory saved by the model")
+		return b.saveMemory(ctx, userID, fact)
+	}
+}
@@ -0,0 +1,185 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mazzz1y/matrix-gpt/internal/gpt"
+	"github.com/mazzz1y/matrix-gpt/internal/store"
+	"github.com/rs/zerolog/log"
+	"maunium.net/go/mautrix/event"
+)
+
+const (
+	// ModerationFlag reacts to moderated messages and logs them, but processes them as usual.
+	ModerationFlag = "flag"
+	// ModerationBlock refuses to process moderated prompts and to send moderated answers.
+	ModerationBlock = "block"
+
+	blockedReaction = "🚫"
+	flaggedReaction = "🚩"
+
+	moderationPrompt = "prompt"
+	moderationAnswer = "answer"
+	moderationImage  = "image"
+
+	// moderationListSize is the number of moderation events shown by `!moderation`.
+	moderationListSize = 20
+)
+
+// moderationConfig holds the moderation settings of the bot. Moderation is disabled if mode is empty.
+type moderationConfig struct {
+	mode string
+	// thresholds are the minimum scores per category. Categories without a threshold match if the endpoint flags them.
+	thresholds map[string]float32
+	answers    bool
+	images     bool
+}
+
+// moderationError is returned if a prompt or an answer is blocked by moderation.
+type moderationError struct {
+	kind       string
+	categories []string
+}
+
+func (e *moderationError) Error() string {
+	return fmt.Sprintf("%s blocked by moderation: %s", e.kind, strings.Join(e.categories, ", "))
+}
+
+// checkModerationMode validates the moderation mode.
+func checkModerationMode(mode string) error {
+	switch mode {
+	case "", ModerationFlag, ModerationBlock:
+		return nil
+	default:
+		return fmt.Errorf("unknown moderation mode %q", mode)
+	}
+}
+
+// parseModerationThresholds parses thresholds in the form `category=score`, e.g. `violence=0.7`.
+func parseModerationThresholds(values []string) (map[string]float32, error) {
+	known := make(map[string]bool, len(gpt.ModerationCategories))
+	for _, c := range gpt.ModerationCategories {
+		known[c] = true
+	}
+
+	thresholds := make(map[string]float32, len(values))
+	for _, v := range values {
+		category, score, ok := strings.Cut(v, "=")
+		if !ok || !known[category] {
+			return nil, fmt.Errorf("invalid moderation threshold %q, categories: %s",
+				v, strings.Join(gpt.ModerationCategories, ", "))
+		}
+
+		f, err := strconv.ParseFloat(score, 32)
+		if err != nil || f < 0 || f > 1 {
+			return nil, fmt.Errorf("invalid moderation threshold %q, the score must be between 0 and 1", v)
+		}
+		thresholds[category] = float32(f)
+	}
+
+	return thresholds, nil
+}
+
+// moderate checks the text if moderation is enabled for its kind. Matching texts are logged and stored for admins.
+// In block mode a moderationError is returned, in flag mode the event gets a reaction.
+// Requests without a Matrix event, e.g. from the API or the scheduler, pass an event with only the room and sender.
+func (b *Bot) moderate(ctx context.Context, evt *event.Event, kind, text string) error {
+	m := b.moderation
+	if m.mode == "" || (kind == moderationAnswer && !m.answers) || (kind == moderationImage && !m.images) {
+		return nil
+	}
+
+	res, err := b.gptClient.Moderate(ctx, text)
+	if err != nil {
+		return err
+	}
+
+	categories := m.matches(res)
+	if len(categories) == 0 {
+		return nil
+	}
+
+	action := "flagged"
+	if m.mode == ModerationBlock {
+		action = "blocked"
+	}
+
+	log.Warn().
+		Str("event", "moderation").
+		Str("user-id", evt.Sender.String()).
+		Str("room-id", evt.RoomID.String()).
+		Str("kind", kind).
+		Str("action", action).
+		Strs("categories", categories).
+		Msg("moderation threshold exceeded")
+
+	err = b.store.AddModerationEvent(ctx, store.ModerationEvent{
+		UserID:     evt.Sender.String(),
+		RoomID:     evt.RoomID.String(),
+		EventID:    evt.ID.String(),
+		Kind:       kind,
+		Action:     action,
+		Categories: strings.Join(categories, ", "),
+		CreatedAt:  time.Now(),
+	})
+	if err != nil {
+		log.Err(err).Msg("moderation event error")
+	}
+
+	if m.mode == ModerationBlock {
+		return &moderationError{kind: kind, categories: categories}
+	}
+
+	if evt.ID != "" {
+		b.reactionResponse(evt, flaggedReaction)
+	}
+	return nil
+}
+
+// matches returns the categories of the result reaching their threshold, and the categories without a threshold
+// flagged by the endpoint.
+func (m moderationConfig) matches(res gpt.Moderation) []string {
+	var categories []string
+	for c, threshold := range m.thresholds {
+		if res.Scores[c] >= threshold {
+			categories = append(categories, c)
+		}
+	}
+	for _, c := range res.Flagged {
+		if _, ok := m.thresholds[c]; !ok {
+			categories = append(categories, c)
+		}
+	}
+	sort.Strings(categories)
+
+	return categories
+}
+
+// moderationResponse lists the latest moderation events. It is only available to admins.
+func (b *Bot) moderationResponse(ctx context.Context, u *user, evt *event.Event, msg string) error {
+	if !b.isAdmin(evt.Sender.String()) {
+		return b.markdownResponse(evt, true, adminOnlyMsg)
+	}
+
+	events, err := b.store.GetModerationEvents(ctx, moderationListSize)
+	if err != nil {
+		return err
+	}
+	if len(events) == 0 {
+		return b.markdownResponse(evt, true, "There are no moderation events.")
+	}
+
+	var sb strings.Builder
+	sb.WriteString("**Moderation events**\n")
+	for _, e := range events {
+		fmt.Fprintf(&sb, "- %s: %s %s by `%s` in `%s` (%s)\n",
+			formatTime(e.CreatedAt), e.Kind, e.Action, e.UserID, e.RoomID, e.Categories)
+	}
+
+	return b.markdownResponse(evt, false, sb.String())
+}
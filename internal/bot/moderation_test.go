@@ -0,0 +1,36 @@
+package bot
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/mazzz1y/matrix-gpt/internal/gpt"
+)
+
+func TestModerationMatches(t *testing.T) {
+	res := gpt.Moderation{
+		Flagged: []string{"hate", "violence"},
+		Scores:  map[string]float32{"hate": 0.9, "sexual": 0.4, "violence": 0.6},
+	}
+
+	tests := []struct {
+		name       string
+		thresholds map[string]float32
+		want       []string
+	}{
+		{"no thresholds", nil, []string{"hate", "violence"}},
+		{"threshold reached", map[string]float32{"sexual": 0.4}, []string{"hate", "sexual", "violence"}},
+		{"threshold not reached", map[string]float32{"violence": 0.7}, []string{"hate"}},
+		{"all thresholds", map[string]float32{"hate": 0.95, "violence": 0.95}, nil},
+		{"lower than flagged", map[string]float32{"hate": 0.5}, []string{"hate", "violence"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := moderationConfig{mode: ModerationBlock, thresholds: tt.thresholds}
+			if got := m.matches(res); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("matches() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,243 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/mazzz1y/matrix-gpt/internal/gpt"
+	"gopkg.in/yaml.v3"
+	"maunium.net/go/mautrix/event"
+)
+
+// personasFile is the content of the personas file.
+type personasFile struct {
+	// Defaults are the generation parameters used unless a persona or the user overrides them.
+	Defaults gpt.Params         `yaml:"defaults"`
+	Personas map[string]persona `yaml:"personas"`
+}
+
+// persona is a named system prompt with its own model and generation parameters.
+type persona struct {
+	Prompt string     `yaml:"prompt"`
+	Model  string     `yaml:"model"`
+	Params gpt.Params `yaml:"params"`
+}
+
+// generation holds the model, system prompt and parameters of a completion.
+type generation struct {
+	Persona string
+	Model   string
+	Prompt  string
+	Params  gpt.Params
+}
+
+// loadPersonas reads the default generation parameters and the personas from a YAML file.
+// An empty path returns no defaults and no personas.
+func loadPersonas(path string) (gpt.Params, map[string]persona, error) {
+	if path == "" {
+		return gpt.Params{}, nil, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return gpt.Params{}, nil, err
+	}
+
+	var f personasFile
+	if err := yaml.Unmarshal(data, &f); err != nil {
+		return gpt.Params{}, nil, fmt.Errorf("invalid personas file: %w", err)
+	}
+
+	if err := f.Defaults.Validate(); err != nil {
+		return gpt.Params{}, nil, fmt.Errorf("invalid defaults: %w", err)
+	}
+	for name, p := range f.Personas {
+		if err := p.Params.Validate(); err != nil {
+			return gpt.Params{}, nil, fmt.Errorf("invalid persona %s: %w", name, err)
+		}
+	}
+
+	return f.Defaults, f.Personas, nil
+}
+
+// allowedModels returns the set of models rooms may select. If none are configured, the default model and the models
+// of the personas are allowed.
+func allowedModels(models []string, defaultModel string, personas map[string]persona) map[string]bool {
+	allowed := make(map[string]bool)
+	if len(models) == 0 {
+		models = []string{defaultModel}
+		for _, p := range personas {
+			models = append(models, p.Model)
+		}
+	}
+
+	for _, m := range models {
+		if m != "" {
+			allowed[m] = true
+		}
+	}
+	return allowed
+}
+
+// checkRoomSetting checks a room setting value that depends on the bot configuration.
+func (b *Bot) checkRoomSetting(key, value string) error {
+	if value == "" {
+		return nil
+	}
+
+	switch key {
+	case "model":
+		if !b.allowedModels[value] {
+			return fmt.Errorf("model must be one of %s", formatNames(b.allowedModels))
+		}
+	case "persona":
+		if _, ok := b.personas[value]; ok || b.customPersonas {
+			return nil
+		}
+		if len(b.personas) == 0 {
+			return errors.New("no personas are configured")
+		}
+		names := make(map[string]bool, len(b.personas))
+		for name := range b.personas {
+			names[name] = true
+		}
+		return fmt.Errorf("persona must be one of %s", formatNames(names))
+	}
+	return nil
+}
+
+// formatNames returns the sorted names as a comma separated list of code spans.
+func formatNames(names map[string]bool) string {
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, "`"+name+"`")
+	}
+	sort.Strings(sorted)
+	return strings.Join(sorted, ", ")
+}
+
+// roomGeneration resolves the persona setting of the room. If it names a persona from the personas file,
+// its prompt, model and parameters are used, otherwise the setting itself is the system prompt if custom personas
+// are allowed. The room model setting takes precedence over the persona model.
+// Values not allowed by the configuration, e.g. set with another client, are ignored.
+func (b *Bot) roomGeneration(s roomSettings) generation {
+	g := generation{Params: b.defaultParams}
+	if b.allowedModels[s.Model] {
+		g.Model = s.Model
+	}
+	if b.customPersonas {
+		g.Prompt = s.Persona
+	}
+
+	if p, ok := b.personas[s.Persona]; ok {
+		g.Persona = s.Persona
+		g.Prompt = p.Prompt
+		g.Params = g.Params.Merge(p.Params)
+		if g.Model == "" {
+			g.Model = p.Model
+		}
+	}
+
+	return g
+}
+
+// userGeneration is roomGeneration with the parameters set by the user with `!set` applied on top.
+func (b *Bot) userGeneration(ctx context.Context, userID string, s roomSettings) (generation, error) {
+	g := b.roomGeneration(s)
+
+	params, err := b.getUserParams(ctx, userID)
+	if err != nil {
+		return g, err
+	}
+
+	g.Params = g.Params.Merge(params)
+	return g, nil
+}
+
+// getUserParams returns the generation parameters set by the user.
+func (b *Bot) getUserParams(ctx context.Context, userID string) (gpt.Params, error) {
+	var params gpt.Params
+
+	data, err := b.store.GetUserParams(ctx, userID)
+	if err != nil || data == nil {
+		return params, err
+	}
+
+	err = json.Unmarshal(data, &params)
+	return params, err
+}
+
+// setResponse sets or unsets a generation parameter of the user, e.g. `!set temperature 0.7` or `!set temperature`.
+func (b *Bot) setResponse(ctx context.Context, u *user, evt *event.Event, msg string) error {
+	name, value, _ := strings.Cut(msg, " ")
+	value = strings.TrimSpace(value)
+	if name == "" {
+		return b.markdownResponse(evt, true, setUsageMsg)
+	}
+
+	userID := evt.Sender.String()
+	params, err := b.getUserParams(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	if err := params.Set(name, value); err != nil {
+		return b.markdownResponse(evt, true, fmt.Sprintf("%s.\n\n%s", err, setUsageMsg))
+	}
+
+	data, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	if err := b.store.PutUserParams(ctx, userID, data); err != nil {
+		return err
+	}
+
+	b.reactionResponse(evt, "✅")
+	return nil
+}
+
+// settingsResponse shows the effective model, persona and generation parameters of the user in the room.
+func (b *Bot) settingsResponse(ctx context.Context, u *user, evt *event.Event, msg string) error {
+	g, err := b.userGeneration(ctx, evt.Sender.String(), b.getRoomSettings(evt.RoomID))
+	if err != nil {
+		return err
+	}
+
+	return b.markdownResponse(evt, false, b.formatGeneration(g))
+}
+
+// formatGeneration returns a readable representation of the generation settings.
+func (b *Bot) formatGeneration(g generation) string {
+	value := func(v string) string {
+		if v == "" {
+			return "default"
+		}
+		return "`" + v + "`"
+	}
+
+	model := g.Model
+	if model == "" {
+		model = b.gptClient.GetModel()
+	}
+
+	var sb strings.Builder
+	sb.WriteString("**Settings**\n")
+	fmt.Fprintf(&sb, "- model: %s\n", value(model))
+	persona := value(g.Persona)
+	if g.Persona == "" && g.Prompt != "" {
+		persona = "custom prompt"
+	}
+	fmt.Fprintf(&sb, "- persona: %s\n", persona)
+	for _, name := range gpt.ParamNames {
+		fmt.Fprintf(&sb, "- %s: %s\n", name, value(g.Params.Get(name)))
+	}
+	sb.WriteString("\n" + setUsageMsg)
+
+	return sb.String()
+}
@@ -0,0 +1,238 @@
+package bot
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/mazzz1y/matrix-gpt/internal/store"
+	"github.com/rs/zerolog/log"
+	"go.mau.fi/util/dbutil"
+	"maunium.net/go/mautrix/crypto/olm"
+)
+
+// LegacyPickleKey is the hardcoded pickle key used by previous versions.
+const LegacyPickleKey = "1337"
+
+// pickledColumn is a database column holding pickled Olm/Megolm objects.
+type pickledColumn struct {
+	table    string
+	column   string
+	repickle func(pickled, oldKey, newKey []byte) ([]byte, error)
+}
+
+// pickledColumns lists all columns of the crypto store encrypted with the pickle key.
+var pickledColumns = []pickledColumn{
+	{"crypto_account", "account", func(p, oldKey, newKey []byte) ([]byte, error) {
+		a, err := olm.AccountFromPickled(p, oldKey)
+		if err != nil {
+			return nil, err
+		}
+		return a.Pickle(newKey), nil
+	}},
+	{"crypto_olm_session", "session", func(p, oldKey, newKey []byte) ([]byte, error) {
+		s, err := olm.SessionFromPickled(p, oldKey)
+		if err != nil {
+			return nil, err
+		}
+		return s.Pickle(newKey), nil
+	}},
+	{"crypto_megolm_inbound_session", "session", func(p, oldKey, newKey []byte) ([]byte, error) {
+		s, err := olm.InboundGroupSessionFromPickled(p, oldKey)
+		if err != nil {
+			return nil, err
+		}
+		return s.Pickle(newKey), nil
+	}},
+	{"crypto_megolm_outbound_session", "session", func(p, oldKey, newKey []byte) ([]byte, error) {
+		s, err := olm.OutboundGroupSessionFromPickled(p, oldKey)
+		if err != nil {
+			return nil, err
+		}
+		return s.Pickle(newKey), nil
+	}},
+}
+
+// pickleKeyPath returns the path of the generated pickle key file next to the database.
+func pickleKeyPath(sqlitePath string) string {
+	return sqlitePath + ".pickle-key"
+}
+
+// configuredPickleKey returns the pickle key from the config value, the config key file or the generated key file.
+// If the generated key file does not exist yet, a new key is returned along with the path it must be written to.
+func configuredPickleKey(cfg Config) (key []byte, pendingPath string, err error) {
+	if cfg.PickleKey != "" {
+		return []byte(cfg.PickleKey), "", nil
+	}
+
+	path := cfg.PickleKeyFile
+	if path == "" {
+		path = pickleKeyPath(cfg.SQLitePath)
+	}
+
+	key, err = os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) && cfg.PickleKeyFile == "" {
+		key, err = generatePickleKey()
+		return key, path, err
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	key = bytes.TrimSpace(key)
+	if len(key) == 0 {
+		return nil, "", fmt.Errorf("pickle key file %s is empty", path)
+	}
+
+	return key, "", nil
+}
+
+// errLegacyPickleKey is returned for stores encrypted with the legacy pickle key, unless AllowLegacyPickleKey is set.
+var errLegacyPickleKey = errors.New("crypto store uses the legacy pickle key, run the migrate-pickle-key command " +
+	"or set allow-legacy-pickle-key to keep using it")
+
+// loadPickleKey returns the pickle key for the crypto store, generating a key file on the first start.
+// Stores created before the pickle key was configurable can only keep using the legacy key if explicitly allowed.
+func loadPickleKey(ctx context.Context, st *store.Store, cfg Config) ([]byte, error) {
+	key, pendingPath, err := configuredPickleKey(cfg)
+	if err != nil || pendingPath == "" {
+		return key, err
+	}
+
+	deviceID, err := st.GetCryptoDeviceID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if deviceID != "" {
+		if !cfg.AllowLegacyPickleKey {
+			return nil, errLegacyPickleKey
+		}
+		log.Warn().Msg("crypto store uses the legacy pickle key, run the migrate-pickle-key command to replace it")
+		return []byte(LegacyPickleKey), nil
+	}
+
+	log.Info().Str("path", pendingPath).Msg("generated new pickle key")
+	return key, writeKeyFile(pendingPath, key)
+}
+
+// MigratePickleKey re-encrypts all Olm/Megolm objects of an existing crypto store from the old key
+// to the key configured in cfg. The whole migration runs in a single transaction.
+func MigratePickleKey(ctx context.Context, cfg Config, oldKey []byte) error {
+	newKey, pendingPath, err := configuredPickleKey(cfg)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(oldKey, newKey) {
+		return errors.New("old and new pickle keys are the same")
+	}
+
+	st, err := store.New(cfg.SQLitePath)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	tx, err := st.DB().BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, c := range pickledColumns {
+		exists, err := st.DB().TableExists(tx, c.table)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			continue
+		}
+
+		n, err := repickleColumn(ctx, tx, c, oldKey, newKey)
+		if err != nil {
+			return fmt.Errorf("%s: %w", c.table, err)
+		}
+		log.Info().Str("table", c.table).Int("rows", n).Msg("re-encrypted")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	if pendingPath != "" {
+		log.Info().Str("path", pendingPath).Msg("generated new pickle key")
+		return writeKeyFile(pendingPath, newKey)
+	}
+
+	return nil
+}
+
+// repickleColumn re-encrypts every non-empty value of the column, returning the number of updated rows.
+func repickleColumn(ctx context.Context, tx dbutil.Execable, c pickledColumn, oldKey, newKey []byte) (int, error) {
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf("SELECT rowid, %s FROM %s", c.column, c.table))
+	if err != nil {
+		return 0, err
+	}
+
+	updated := make(map[int64][]byte)
+	for rows.Next() {
+		var rowID int64
+		var pickled []byte
+		if err := rows.Scan(&rowID, &pickled); err != nil {
+			_ = rows.Close()
+			return 0, err
+		}
+		if len(pickled) == 0 {
+			continue
+		}
+
+		repickled, err := c.repickle(pickled, oldKey, newKey)
+		if err != nil {
+			_ = rows.Close()
+			return 0, err
+		}
+		updated[rowID] = repickled
+	}
+	if err := rows.Close(); err != nil {
+		return 0, err
+	}
+
+	query := fmt.Sprintf("UPDATE %s SET %s=$1 WHERE rowid=$2", c.table, c.column)
+	for rowID, pickled := range updated {
+		if _, err := tx.ExecContext(ctx, query, pickled, rowID); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(updated), nil
+}
+
+// generatePickleKey returns a new random pickle key.
+func generatePickleKey() ([]byte, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, err
+	}
+
+	key := make([]byte, base64.RawStdEncoding.EncodedLen(len(raw)))
+	base64.RawStdEncoding.Encode(key, raw)
+	return key, nil
+}
+
+// writeKeyFile writes the key to a file readable only by the owner, failing if the file already exists.
+func writeKeyFile(path string, key []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(key); err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	return f.Close()
+}
@@ -0,0 +1,111 @@
+package bot
+
+import (
+	"context"
+
+	"github.com/rs/zerolog/log"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+const queuedReaction = "⏳"
+
+// semaphore limits the number of concurrent holders. A nil semaphore is unlimited.
+type semaphore chan struct{}
+
+// newSemaphore creates a semaphore with the given size. Size 0 means unlimited.
+func newSemaphore(size int) semaphore {
+	if size <= 0 {
+		return nil
+	}
+	return make(semaphore, size)
+}
+
+// tryAcquire acquires the semaphore if it is free, without waiting.
+func (s semaphore) tryAcquire() bool {
+	if s == nil {
+		return true
+	}
+
+	select {
+	case s <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// acquire waits until the semaphore is acquired or the context is done.
+func (s semaphore) acquire(ctx context.Context) error {
+	if s == nil {
+		return nil
+	}
+
+	select {
+	case s <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// release releases a previously acquired semaphore.
+func (s semaphore) release() {
+	if s != nil {
+		<-s
+	}
+}
+
+// acquireSlot waits for a free per-user and global slot for the request.
+// While the request is queued, it is marked with a reaction, which is removed once processing starts.
+// The returned function must be called to release the slots.
+func (b *Bot) acquireSlot(ctx context.Context, u *user, evt *event.Event) (func(), error) {
+	userAcquired := u.slots.tryAcquire()
+	if userAcquired && b.slots.tryAcquire() {
+		return b.releaseSlot(u), nil
+	}
+
+	queued := b.markQueued(evt)
+	defer b.unmarkQueued(evt.RoomID, queued)
+
+	log.Debug().
+		Str("user-id", evt.Sender.String()).
+		Int("queue-size", u.getQueueSize()).
+		Msg("request queued")
+
+	if !userAcquired {
+		if err := u.slots.acquire(ctx); err != nil {
+			return nil, err
+		}
+	}
+	if err := b.slots.acquire(ctx); err != nil {
+		u.slots.release()
+		return nil, err
+	}
+
+	return b.releaseSlot(u), nil
+}
+
+// releaseSlot returns a function releasing the per-user and global slots.
+func (b *Bot) releaseSlot(u *user) func() {
+	return func() {
+		b.slots.release()
+		u.slots.release()
+	}
+}
+
+// markQueued reacts to a queued request, returning the reaction event ID.
+func (b *Bot) markQueued(evt *event.Event) id.EventID {
+	resp, err := b.client.SendReaction(evt.RoomID, evt.ID, queuedReaction)
+	if err != nil {
+		return ""
+	}
+	return resp.EventID
+}
+
+// unmarkQueued removes the queued reaction once the request leaves the queue.
+func (b *Bot) unmarkQueued(roomID id.RoomID, reactionID id.EventID) {
+	if reactionID != "" {
+		_, _ = b.client.RedactEvent(roomID, reactionID)
+	}
+}
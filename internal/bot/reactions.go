@@ -0,0 +1,203 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/mazzz1y/matrix-gpt/internal/gpt"
+	"github.com/rs/zerolog/log"
+	"github.com/sashabaranov/go-openai"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/format"
+)
+
+const (
+	regenerateReaction = "🔄"
+	likeReaction       = "👍"
+	dislikeReaction    = "👎"
+	truncateReaction   = "✂"
+
+	// retryTemperatureStep is the change of the sampling temperature used to retry a disliked answer,
+	// to get a different answer.
+	retryTemperatureStep = 0.3
+)
+
+var errNotLastAnswer = errors.New("only the last answer can be regenerated")
+
+// reactionHandler handles reactions of allowed users to the messages in their history.
+// 🔄 regenerates the last answer, 👍 records positive feedback, 👎 records negative feedback and retries the last
+// answer with a different temperature, ✂️ truncates the history after the message.
+func (b *Bot) reactionHandler(source mautrix.EventSource, evt *event.Event) {
+	userID := evt.Sender.String()
+	if b.client.UserID.String() == userID || b.stopping.Load() {
+		return
+	}
+
+	u, ok := b.users[userID]
+	if !ok {
+		return
+	}
+
+	rel := evt.Content.AsReaction().RelatesTo
+	key := strings.TrimSuffix(rel.Key, "\ufe0f")
+	if key != regenerateReaction && key != likeReaction && key != dislikeReaction && key != truncateReaction {
+		return
+	}
+
+	l := log.With().
+		Str("event", "reaction").
+		Str("user-id", userID).
+		Str("reaction", key).
+		Logger()
+
+	// Ratings are looked up by the answer event, so older answers that left the history can be rated as well.
+	if key == likeReaction || key == dislikeReaction {
+		rating := ratingPositive
+		if key == dislikeReaction {
+			rating = ratingNegative
+		}
+		if err := b.recordFeedback(b.reqCtx, evt, rating); err != nil {
+			l.Err(err).Msg("feedback error")
+		}
+		if key == likeReaction {
+			return
+		}
+	}
+
+	pos := u.history.position(rel.EventID)
+	if pos < 0 {
+		return
+	}
+
+	if !b.beginRequest() {
+		return
+	}
+
+	if key == truncateReaction {
+		go func() {
+			defer b.inFlight.Done()
+
+			// The lock is taken in the background, a regeneration may hold it until its answer is complete.
+			unlock, err := u.lockTurn(b.reqCtx)
+			if err != nil {
+				return
+			}
+			defer unlock()
+
+			// The history may have changed while waiting for the lock.
+			if pos := u.history.position(rel.EventID); pos >= 0 {
+				u.history.truncate(pos + 1)
+				l.Debug().Int("history-size", u.history.getSize()).Msg("history truncated")
+			}
+		}()
+		return
+	}
+
+	go func() {
+		defer b.inFlight.Done()
+
+		evtID := evt.ID.String()
+		ctx := u.createRequestContext(b.reqCtx, evtID)
+		defer u.cancelRequestContext(evtID)
+
+		var override gpt.Params
+		if key == dislikeReaction {
+			override.Temperature = b.retryTemperature(*ctx, evt)
+		}
+
+		// The queue and error reactions are put on the answer, not on the reaction.
+		answer := &event.Event{RoomID: evt.RoomID, ID: rel.EventID, Sender: evt.Sender}
+		err := b.regenerate(*ctx, u, answer, override)
+		switch {
+		case errors.Is(err, errNotLastAnswer):
+			l.Debug().Msg("not the last answer, reaction ignored")
+		case errors.Is(err, context.Canceled):
+		case err != nil:
+			b.err(answer, err)
+			l.Err(err).Msg("regenerate error")
+		default:
+			u.updateLastMsgTime()
+			l.Debug().Msg("answer regenerated")
+		}
+	}()
+}
+
+// regenerate replaces the last answer of the user with a new one, editing the answer message.
+// The answer is checked again once it is the user's turn, since the history may have changed while queued.
+func (b *Bot) regenerate(ctx context.Context, u *user, answer *event.Event, override gpt.Params) error {
+	if _, _, err := lastAnswer(u, answer); err != nil {
+		return err
+	}
+
+	release, err := b.acquireSlot(ctx, u, answer)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	unlock, err := u.lockTurn(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	history, pos, err := lastAnswer(u, answer)
+	if err != nil {
+		return err
+	}
+	userEvtID := u.history.eventID(pos - 1)
+
+	b.startTyping(answer.RoomID)
+	defer b.stopTyping(answer.RoomID)
+
+	// The turn lock is held, so the history can't be reset and the mapping stays the one of the history.
+	placeholders := u.mapping()
+	prev := append([]openai.ChatCompletionMessage(nil), history[:pos-1]...)
+	newHistory, text, err := b.complete(ctx, answer, placeholders, prev, history[pos-1].Content, override)
+	if err != nil {
+		return err
+	}
+
+	content := format.RenderMarkdown(placeholders.Restore(text), true, false)
+	content.SetEdit(answer.ID)
+	if _, err := b.client.SendMessageEvent(answer.RoomID, event.EventMessage, &content); err != nil {
+		return err
+	}
+
+	// Drop the old turn, so the entries before the new one are the current history for saveTurn.
+	u.history.truncate(pos - 1)
+	u.history.saveTurn(newHistory, userEvtID, answer.ID)
+	b.recordAnswer(ctx, answer, answer.ID, newHistory)
+	return nil
+}
+
+// lastAnswer returns the history of the user and the position of the answer in it,
+// or errNotLastAnswer if the answer is not the last entry following a user message.
+func lastAnswer(u *user, answer *event.Event) ([]openai.ChatCompletionMessage, int, error) {
+	history := u.history.get()
+	pos := u.history.position(answer.ID)
+	if pos != len(history)-1 || pos < 1 || history[pos].Role != openai.ChatMessageRoleAssistant ||
+		history[pos-1].Role != openai.ChatMessageRoleUser {
+		return nil, 0, errNotLastAnswer
+	}
+	return history, pos, nil
+}
+
+// retryTemperature returns a sampling temperature different from the effective one of the user in the room,
+// higher unless it is already above the default.
+func (b *Bot) retryTemperature(ctx context.Context, evt *event.Event) *float32 {
+	var temperature float32 = 1
+	g, err := b.userGeneration(ctx, evt.Sender.String(), b.getRoomSettings(evt.RoomID))
+	if err == nil && g.Params.Temperature != nil {
+		temperature = *g.Params.Temperature
+	}
+
+	if temperature > 1 {
+		temperature -= retryTemperatureStep
+	} else {
+		temperature += retryTemperatureStep
+	}
+	return &temperature
+}
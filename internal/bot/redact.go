@@ -0,0 +1,54 @@
+package bot
+
+import (
+	"context"
+
+	"github.com/mazzz1y/matrix-gpt/internal/kb"
+	"github.com/mazzz1y/matrix-gpt/internal/redact"
+)
+
+// newRedactor creates the redactor of the configuration, or returns nil if redaction is disabled.
+func newRedactor(cfg Config) (*redact.Redactor, error) {
+	if !cfg.Redact {
+		return nil, nil
+	}
+
+	return redact.New(redact.Config{
+		Detectors:       cfg.RedactDetectors,
+		InternalDomains: cfg.RedactDomains,
+		Patterns:        cfg.RedactPatterns,
+		Keywords:        cfg.RedactKeywords,
+	})
+}
+
+// redact replaces personal data and secrets in the text with placeholders before it is sent to OpenAI. They are
+// recorded in the mapping of the user, or in a new mapping for requests without a user, e.g. from the API.
+// The returned mapping restores the placeholders in the answer. The text is unchanged if redaction is disabled.
+func (b *Bot) redact(u *user, text string) (string, *redact.Mapping) {
+	var m *redact.Mapping
+	if u != nil {
+		m = u.mapping()
+	} else {
+		m = redact.NewMapping()
+	}
+
+	return b.redactor.Redact(text, m), m
+}
+
+// redactingEmbedder redacts the texts before their embeddings are computed. The knowledge base stores the original
+// chunks, they are redacted with the mapping of the user when they are added to a prompt.
+type redactingEmbedder struct {
+	embedder kb.Embedder
+	redactor *redact.Redactor
+}
+
+// CreateEmbeddings computes the embeddings of the redacted texts.
+func (e redactingEmbedder) CreateEmbeddings(ctx context.Context, texts []string) ([][]float32, error) {
+	m := redact.NewMapping()
+	redacted := make([]string, len(texts))
+	for i, t := range texts {
+		redacted[i] = e.redactor.Redact(t, m)
+	}
+
+	return e.embedder.CreateEmbeddings(ctx, redacted)
+}
@@ -0,0 +1,73 @@
+package bot
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/mazzz1y/matrix-gpt/internal/redact"
+)
+
+// embedderFunc is a kb.Embedder recording the texts it gets.
+type embedderFunc func(texts []string)
+
+func (f embedderFunc) CreateEmbeddings(ctx context.Context, texts []string) ([][]float32, error) {
+	f(texts)
+	return make([][]float32, len(texts)), nil
+}
+
+func TestRedact(t *testing.T) {
+	r, err := redact.New(redact.Config{Detectors: []string{redact.Email}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &Bot{redactor: r}
+	u := newGptUser(10, 1)
+
+	text, m := b.redact(u, "mail a@example.com")
+	if strings.Contains(text, "a@example.com") {
+		t.Fatalf("redact() = %q, want the address replaced", text)
+	}
+	if m != u.mapping() {
+		t.Error("redact() with a user returned another mapping than the one of the user")
+	}
+
+	// The mapping of a request keeps restoring its placeholders after the history was reset.
+	u.resetHistory()
+	if got := m.Restore(text); got != "mail a@example.com" {
+		t.Errorf("Restore(%q) after the reset = %q, want the original", text, got)
+	}
+	if got := u.mapping().Restore(text); got != text {
+		t.Errorf("Restore(%q) with the new mapping = %q, want it unchanged", text, got)
+	}
+
+	if text, m := b.redact(nil, "mail a@example.com"); m.Restore(text) != "mail a@example.com" {
+		t.Errorf("redact() without a user = %q, can't be restored", text)
+	}
+
+	disabled := &Bot{}
+	if text, _ := disabled.redact(u, "mail a@example.com"); text != "mail a@example.com" {
+		t.Errorf("redact() without a redactor = %q, want the text unchanged", text)
+	}
+}
+
+func TestRedactingEmbedder(t *testing.T) {
+	r, err := redact.New(redact.Config{Detectors: []string{redact.Email}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	e := redactingEmbedder{embedder: embedderFunc(func(texts []string) { got = texts }), redactor: r}
+	texts := []string{"contact a@example.com", "no data"}
+	if _, err := e.CreateEmbeddings(context.Background(), texts); err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(got[0], "a@example.com") || got[1] != "no data" {
+		t.Errorf("CreateEmbeddings() sent %q, want the address redacted", got)
+	}
+	if texts[0] != "contact a@example.com" {
+		t.Errorf("CreateEmbeddings() changed the texts to %q", texts)
+	}
+}
@@ -0,0 +1,173 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/rs/zerolog/log"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/format"
+	"maunium.net/go/mautrix/id"
+)
+
+// invitePolicy holds the rules for rooms the bot accepts invites to and stays in.
+type invitePolicy struct {
+	dmOnly     bool
+	maxMembers int
+	servers    []string
+}
+
+// checkInvite returns an error describing why an invite must be rejected, or nil if it can be accepted.
+func (p invitePolicy) checkInvite(evt *event.Event) error {
+	if p.dmOnly && !evt.Content.AsMember().IsDirect {
+		return fmt.Errorf("not a direct message")
+	}
+
+	if len(p.servers) == 0 {
+		return nil
+	}
+
+	server := evt.Sender.Homeserver()
+	for _, s := range p.servers {
+		if s == server {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("server %s is not allowed", server)
+}
+
+// acceptInvite joins the room if the invite matches the policy, checks the members and sends a welcome message.
+// Invites that don't match the policy are rejected.
+func (b *Bot) acceptInvite(evt *event.Event) error {
+	l := log.With().Str("room-id", evt.RoomID.String()).Logger()
+
+	if err := b.invitePolicy.checkInvite(evt); err != nil {
+		l.Info().Err(err).Msg("invite rejected")
+		_, err := b.client.LeaveRoom(evt.RoomID)
+		return err
+	}
+
+	alreadyJoined := b.client.StateStore != nil && b.client.StateStore.IsMembership(evt.RoomID, b.client.UserID, event.MembershipJoin)
+
+	if _, err := b.client.JoinRoomByID(evt.RoomID); err != nil {
+		return err
+	}
+
+	left, err := b.checkRoomMembers(evt.RoomID)
+	if err != nil || left || alreadyJoined {
+		return err
+	}
+
+	b.loadEncryptionState(evt.RoomID)
+	_, err = b.sendMarkdown(evt.RoomID, welcomeMsg+helpMsg)
+	return err
+}
+
+// checkRoomMembers leaves the room if no allowed user is a member anymore or if it has too many members.
+// It reports whether the room was left.
+func (b *Bot) checkRoomMembers(roomID id.RoomID) (bool, error) {
+	joined, err := b.joinedMembers(roomID)
+	if err != nil {
+		return false, err
+	}
+
+	if b.invitePolicy.maxMembers > 0 && len(joined) > b.invitePolicy.maxMembers {
+		return true, b.leaveRoom(roomID, "too many members")
+	}
+
+	for _, userID := range joined {
+		if _, ok := b.users[userID.String()]; ok {
+			return false, nil
+		}
+	}
+
+	return true, b.leaveRoom(roomID, "no allowed members left")
+}
+
+// joinedMembers returns the joined members of the room from the state store,
+// falling back to the server if the store doesn't know the room's members.
+func (b *Bot) joinedMembers(roomID id.RoomID) ([]id.UserID, error) {
+	if store := b.client.StateStore; store != nil {
+		members, err := store.GetRoomJoinedOrInvitedMembers(roomID)
+		if err == nil && len(members) > 0 {
+			joined := members[:0]
+			for _, userID := range members {
+				if store.IsMembership(roomID, userID, event.MembershipJoin) {
+					joined = append(joined, userID)
+				}
+			}
+			return joined, nil
+		}
+	}
+
+	resp, err := b.client.JoinedMembers(roomID)
+	if err != nil {
+		return nil, err
+	}
+
+	joined := make([]id.UserID, 0, len(resp.Joined))
+	for userID := range resp.Joined {
+		joined = append(joined, userID)
+	}
+	return joined, nil
+}
+
+// checkJoinedRooms checks the members of all rooms the bot is in, leaving the ones that don't match the policy anymore.
+func (b *Bot) checkJoinedRooms() {
+	resp, err := b.client.JoinedRooms()
+	if err != nil {
+		log.Err(err).Msg("joined rooms error")
+		return
+	}
+
+	for _, roomID := range resp.JoinedRooms {
+		if _, err := b.checkRoomMembers(roomID); err != nil {
+			log.Err(err).Str("room-id", roomID.String()).Msg("room members check error")
+		}
+	}
+}
+
+// leaveRoom leaves the room, logging the reason.
+func (b *Bot) leaveRoom(roomID id.RoomID, reason string) error {
+	log.Info().Str("room-id", roomID.String()).Str("reason", reason).Msg("leaving room")
+	_, err := b.client.LeaveRoom(roomID)
+	return err
+}
+
+// loadEncryptionState fetches the encryption state of a just joined room into the state store,
+// so that the first message is encrypted even before the room shows up in a sync.
+func (b *Bot) loadEncryptionState(roomID id.RoomID) {
+	if b.client.StateStore == nil {
+		return
+	}
+
+	var content event.EncryptionEventContent
+	if err := b.client.StateEvent(roomID, event.StateEncryption, "", &content); err == nil {
+		b.client.StateStore.SetEncryptionEvent(roomID, &content)
+	} else if !errors.Is(err, mautrix.MNotFound) {
+		log.Debug().Err(err).Str("room-id", roomID.String()).Msg("encryption state error")
+	}
+}
+
+// sendMarkdown sends a message in markdown format to the room.
+func (b *Bot) sendMarkdown(roomID id.RoomID, msg string) (id.EventID, error) {
+	formattedMsg := format.RenderMarkdown(msg, true, false)
+	resp, err := b.client.SendMessageEvent(roomID, event.EventMessage, &formattedMsg)
+	if err != nil {
+		return "", err
+	}
+	return resp.EventID, nil
+}
+
+// sendAnswer sends the answer to the message in markdown format to its room, or to the thread of a scheduled message.
+func (b *Bot) sendAnswer(evt *event.Event, msg string) (id.EventID, error) {
+	formattedMsg := format.RenderMarkdown(msg, true, false)
+	formattedMsg.RelatesTo = scheduledRelation(evt)
+	resp, err := b.client.SendMessageEvent(evt.RoomID, event.EventMessage, &formattedMsg)
+	if err != nil {
+		return "", err
+	}
+	return resp.EventID, nil
+}
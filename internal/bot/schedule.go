@@ -0,0 +1,272 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mazzz1y/matrix-gpt/internal/schedule"
+	"github.com/mazzz1y/matrix-gpt/internal/store"
+	"github.com/rs/zerolog/log"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/format"
+	"maunium.net/go/mautrix/id"
+)
+
+const (
+	// schedulerInterval is how often the scheduler checks for due jobs.
+	schedulerInterval = 30 * time.Second
+	// pruneInterval is how often the scheduler deletes answers older than the retention period.
+	pruneInterval = time.Hour
+
+	// maxJobs is the maximum number of scheduled prompts and reminders per user.
+	maxJobs = 20
+
+	jobKindPrompt   = "prompt"
+	jobKindReminder = "reminder"
+)
+
+var errTooManyJobs = fmt.Errorf("you can't schedule more than %d jobs, use `!schedule delete <id>` to remove some", maxJobs)
+
+// scheduleResponse lists, deletes or adds scheduled prompts.
+// Usage: `!schedule`, `!schedule delete <id>` or `!schedule <spec> <prompt>`.
+func (b *Bot) scheduleResponse(ctx context.Context, u *user, evt *event.Event, msg string) error {
+	fields := strings.Fields(msg)
+	switch {
+	case len(fields) == 0 || (len(fields) == 1 && fields[0] == "list"):
+		return b.listJobs(ctx, evt)
+	case len(fields) == 2 && fields[0] == "delete":
+		return b.deleteJob(ctx, evt, fields[1])
+	default:
+		return b.addJob(ctx, evt, jobKindPrompt, msg)
+	}
+}
+
+// remindResponse adds a reminder, e.g. `!remind in 2h check the deploy`.
+func (b *Bot) remindResponse(ctx context.Context, u *user, evt *event.Event, msg string) error {
+	return b.addJob(ctx, evt, jobKindReminder, msg)
+}
+
+// addJob parses the schedule from the message and stores the job.
+func (b *Bot) addJob(ctx context.Context, evt *event.Event, kind, msg string) error {
+	now := time.Now()
+	sched, text, err := schedule.Split(msg, now)
+	if err != nil {
+		return b.markdownResponse(evt, true, err.Error())
+	}
+	if text == "" {
+		return b.markdownResponse(evt, true, scheduleUsageMsg)
+	}
+
+	if cmd := extractCommand(text); cmd != "" {
+		if _, err := b.getAction(cmd); err != nil {
+			return err
+		}
+	}
+
+	count, err := b.store.CountUserJobs(ctx, evt.Sender.String())
+	if err != nil {
+		return err
+	}
+	if count >= maxJobs {
+		return b.markdownResponse(evt, true, errTooManyJobs.Error())
+	}
+
+	job := store.Job{
+		UserID:   evt.Sender.String(),
+		RoomID:   evt.RoomID.String(),
+		ThreadID: evt.Content.AsMessage().OptionalGetRelatesTo().GetThreadParent().String(),
+		Kind:     kind,
+		Spec:     sched.String(),
+		Message:  text,
+		NextRun:  sched.Next(now),
+	}
+
+	jobID, err := b.store.AddJob(ctx, job)
+	if err != nil {
+		return err
+	}
+
+	return b.markdownResponse(evt, true, fmt.Sprintf("Scheduled `#%d`, next run: %s.", jobID, formatTime(job.NextRun)))
+}
+
+// listJobs responds with the jobs of the user in the room.
+func (b *Bot) listJobs(ctx context.Context, evt *event.Event) error {
+	jobs, err := b.store.GetUserJobs(ctx, evt.Sender.String(), evt.RoomID.String())
+	if err != nil {
+		return err
+	}
+	if len(jobs) == 0 {
+		return b.markdownResponse(evt, true, "No scheduled jobs. "+scheduleUsageMsg)
+	}
+
+	var sb strings.Builder
+	sb.WriteString("**Scheduled jobs**\n")
+	for _, j := range jobs {
+		fmt.Fprintf(&sb, "- `#%d` %s `%s` (next: %s): %s\n", j.ID, j.Kind, j.Spec, formatTime(j.NextRun), j.Message)
+	}
+
+	return b.markdownResponse(evt, false, sb.String())
+}
+
+// deleteJob deletes a job of the user.
+func (b *Bot) deleteJob(ctx context.Context, evt *event.Event, arg string) error {
+	jobID, err := strconv.ParseInt(strings.TrimPrefix(arg, "#"), 10, 64)
+	if err != nil {
+		return b.markdownResponse(evt, true, scheduleUsageMsg)
+	}
+
+	ok, err := b.store.DeleteJob(ctx, jobID, evt.Sender.String())
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return b.markdownResponse(evt, true, fmt.Sprintf("Job `#%d` not found.", jobID))
+	}
+
+	b.reactionResponse(evt, "✅")
+	return nil
+}
+
+// schedulerLoop runs due jobs and prunes old answers until the context is cancelled.
+func (b *Bot) schedulerLoop(ctx context.Context) {
+	ticker := time.NewTicker(schedulerInterval)
+	defer ticker.Stop()
+
+	b.pruneAnswers(ctx)
+	lastPrune := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			b.runDueJobs(ctx)
+			if now.Sub(lastPrune) >= pruneInterval {
+				b.pruneAnswers(ctx)
+				lastPrune = now
+			}
+		}
+	}
+}
+
+// runDueJobs starts all due jobs. Recurring jobs are rescheduled and one-time jobs are deleted before they run,
+// so that a job is never executed twice.
+func (b *Bot) runDueJobs(ctx context.Context) {
+	now := time.Now()
+	jobs, err := b.store.GetDueJobs(ctx, now)
+	if err != nil {
+		log.Err(err).Msg("scheduler error")
+		return
+	}
+
+	for _, job := range jobs {
+		l := log.With().
+			Str("event", "schedule").
+			Str("user-id", job.UserID).
+			Int64("job-id", job.ID).
+			Logger()
+
+		sched, err := schedule.Parse(job.Spec, now)
+		_, allowed := b.users[job.UserID]
+		if err != nil || !sched.Recurring() || !allowed {
+			_, err = b.store.DeleteJob(ctx, job.ID, job.UserID)
+		} else {
+			err = b.store.UpdateJobNextRun(ctx, job.ID, sched.Next(now))
+		}
+		if err != nil {
+			l.Err(err).Msg("scheduler error")
+			continue
+		}
+		if !allowed || !b.beginRequest() {
+			continue
+		}
+
+		go func(job store.Job) {
+			defer b.inFlight.Done()
+
+			if err := b.runJob(job); err != nil {
+				l.Err(err).Msg("scheduled job error")
+			}
+		}(job)
+	}
+}
+
+// runJob posts the reminder to the room of the job, or handles the prompt like a message of the user in the room
+// and the thread of the job, so commands such as `!summarize since 24h` can be scheduled too.
+func (b *Bot) runJob(job store.Job) error {
+	roomID := id.RoomID(job.RoomID)
+
+	if job.Kind == jobKindReminder {
+		userID := id.UserID(job.UserID)
+		content := format.RenderMarkdown(fmt.Sprintf("⏰ [%s](%s): %s", userID, userID.URI().MatrixToURL(), job.Message), true, false)
+		content.Mentions = &event.Mentions{UserIDs: []id.UserID{userID}}
+		return b.sendJobResult(job, &content)
+	}
+
+	ctx := b.reqCtx
+	if err := b.slots.acquire(ctx); err != nil {
+		return err
+	}
+	defer b.slots.release()
+
+	u, ok := b.users[job.UserID]
+	if !ok {
+		return nil
+	}
+
+	evt := jobEvent(job)
+	body := evt.Content.AsMessage().Body
+	action, err := b.getAction(extractCommand(body))
+	if err != nil {
+		return err
+	}
+
+	b.startTyping(roomID)
+	defer b.stopTyping(roomID)
+
+	return action(ctx, u, evt, trimCommand(body))
+}
+
+// jobEvent returns a message event of the user with the prompt of the job, in the room and thread of the job.
+// It has no event ID, responses to it don't reply or react to it.
+func jobEvent(job store.Job) *event.Event {
+	content := &event.MessageEventContent{MsgType: event.MsgText, Body: job.Message}
+	if job.ThreadID != "" {
+		threadID := id.EventID(job.ThreadID)
+		content.RelatesTo = (&event.RelatesTo{}).SetThread(threadID, threadID)
+	}
+
+	return &event.Event{
+		Type:      event.EventMessage,
+		RoomID:    id.RoomID(job.RoomID),
+		Sender:    id.UserID(job.UserID),
+		Timestamp: time.Now().UnixMilli(),
+		Content:   event.Content{Parsed: content},
+	}
+}
+
+// scheduledRelation returns the thread relation of a scheduled message, or nil for other messages.
+func scheduledRelation(evt *event.Event) *event.RelatesTo {
+	if evt.ID != "" {
+		return nil
+	}
+	return evt.Content.AsMessage().OptionalGetRelatesTo()
+}
+
+// sendJobResult sends the content to the room of the job, inside the thread the job was created in, if any.
+func (b *Bot) sendJobResult(job store.Job, content *event.MessageEventContent) error {
+	if job.ThreadID != "" {
+		threadID := id.EventID(job.ThreadID)
+		content.RelatesTo = (&event.RelatesTo{}).SetThread(threadID, threadID)
+	}
+
+	_, err := b.client.SendMessageEvent(id.RoomID(job.RoomID), event.EventMessage, content)
+	return err
+}
+
+// formatTime formats a job run time in the local time zone.
+func formatTime(t time.Time) string {
+	return t.Local().Format("2006-01-02 15:04 MST")
+}
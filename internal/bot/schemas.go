@@ -0,0 +1,111 @@
+package bot
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/mazzz1y/matrix-gpt/internal/gpt"
+	"gopkg.in/yaml.v3"
+	"maunium.net/go/mautrix/event"
+)
+
+// loadSchemas reads the named JSON schemas for `!json` from a YAML file. An empty path returns no schemas.
+func loadSchemas(path string) (map[string]*gpt.Schema, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// Unsupported keywords are rejected instead of being silently ignored.
+	var schemas map[string]*gpt.Schema
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&schemas); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("invalid schemas file: %w", err)
+	}
+
+	for name, s := range schemas {
+		if s == nil {
+			return nil, fmt.Errorf("invalid schema %s: empty schema", name)
+		}
+		// JSON mode only returns objects.
+		if s.Type != "object" {
+			return nil, fmt.Errorf("invalid schema %s: the root must be of type object", name)
+		}
+		if err := s.Check(); err != nil {
+			return nil, fmt.Errorf("invalid schema %s: %w", name, err)
+		}
+	}
+
+	return schemas, nil
+}
+
+// jsonResponse extracts structured data from the text as JSON matching a configured schema,
+// e.g. `!json action_items <meeting notes>`. Without arguments, it lists the schemas.
+func (b *Bot) jsonResponse(ctx context.Context, u *user, evt *event.Event, msg string) error {
+	name, text, _ := strings.Cut(msg, " ")
+	text = strings.TrimSpace(text)
+	if name == "" || text == "" {
+		return b.markdownResponse(evt, true, b.jsonUsage())
+	}
+
+	schema, ok := b.schemas[name]
+	if !ok {
+		return b.markdownResponse(evt, true, fmt.Sprintf("Schema `%s` not found.\n\n%s", name, b.jsonUsage()))
+	}
+
+	text, placeholders := b.redact(u, text)
+	if err := b.moderate(ctx, evt, moderationPrompt, text); err != nil {
+		return err
+	}
+
+	g, err := b.userGeneration(ctx, evt.Sender.String(), b.getRoomSettings(evt.RoomID))
+	if err != nil {
+		return err
+	}
+
+	res, err := b.gptClient.CreateJSON(ctx, schema, text, gpt.CompletionOptions{Model: g.Model, Params: g.Params})
+	if errors.Is(err, gpt.ErrInvalidJSON) {
+		return b.markdownResponse(evt, true, "The model didn't return valid JSON for this schema, please try again.")
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := b.moderate(ctx, evt, moderationAnswer, string(res)); err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, []byte(placeholders.RestoreJSON(string(res))), "", "  "); err != nil {
+		return err
+	}
+
+	return b.markdownResponse(evt, true, "```json\n"+buf.String()+"\n```")
+}
+
+// jsonUsage returns the usage of `!json` with the configured schema names.
+func (b *Bot) jsonUsage() string {
+	if len(b.schemas) == 0 {
+		return "No JSON schemas are configured."
+	}
+
+	names := make([]string, 0, len(b.schemas))
+	for name := range b.schemas {
+		names = append(names, "`"+name+"`")
+	}
+	sort.Strings(names)
+
+	return jsonUsageMsg + " Schemas: " + strings.Join(names, ", ") + "."
+}
@@ -0,0 +1,216 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+const (
+	// triggerAlways makes the bot respond to every message in the room.
+	triggerAlways = "always"
+	// triggerMention makes the bot respond only to commands and messages mentioning it.
+	triggerMention = "mention"
+)
+
+// settingsEventType is the room state event holding the per-room settings.
+var settingsEventType = event.Type{Type: "org.matrix-gpt.settings", Class: event.StateEventType}
+
+// roomSettings is the content of the settings state event. Empty values fall back to the bot configuration.
+type roomSettings struct {
+	Persona      string `json:"persona,omitempty"`
+	Model        string `json:"model,omitempty"`
+	Trigger      string `json:"trigger,omitempty"`
+	HistoryLimit int    `json:"history_limit,omitempty"`
+	Language     string `json:"language,omitempty"`
+}
+
+// settingsCache caches the settings of the rooms the bot has seen.
+type settingsCache struct {
+	sync.RWMutex
+	rooms map[id.RoomID]roomSettings
+}
+
+// roomSettingKeys lists the keys accepted by `!room set`, with a function applying a value.
+var roomSettingKeys = map[string]func(s *roomSettings, value string) error{
+	"persona": func(s *roomSettings, value string) error {
+		s.Persona = value
+		return nil
+	},
+	"model": func(s *roomSettings, value string) error {
+		s.Model = value
+		return nil
+	},
+	"trigger": func(s *roomSettings, value string) error {
+		if value != "" && value != triggerAlways && value != triggerMention {
+			return fmt.Errorf("trigger must be %q or %q", triggerAlways, triggerMention)
+		}
+		s.Trigger = value
+		return nil
+	},
+	"language": func(s *roomSettings, value string) error {
+		s.Language = value
+		return nil
+	},
+	"history_limit": func(s *roomSettings, value string) error {
+		if value == "" {
+			s.HistoryLimit = 0
+			return nil
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return errors.New("history_limit must be a positive number")
+		}
+		s.HistoryLimit = n
+		return nil
+	},
+}
+
+// getRoomSettings returns the cached settings of the room, fetching the state event on the first access.
+func (b *Bot) getRoomSettings(roomID id.RoomID) roomSettings {
+	b.settings.RLock()
+	s, ok := b.settings.rooms[roomID]
+	b.settings.RUnlock()
+	if ok {
+		return s
+	}
+
+	err := b.client.StateEvent(roomID, settingsEventType, "", &s)
+	if err != nil && !errors.Is(err, mautrix.MNotFound) {
+		log.Err(err).Str("room-id", roomID.String()).Msg("room settings error")
+		return s
+	}
+
+	b.setRoomSettings(roomID, s)
+	return s
+}
+
+// setRoomSettings updates the cached settings of the room.
+func (b *Bot) setRoomSettings(roomID id.RoomID, s roomSettings) {
+	b.settings.Lock()
+	defer b.settings.Unlock()
+
+	b.settings.rooms[roomID] = s
+}
+
+// settingsHandler updates the cache when the settings state event changes.
+func (b *Bot) settingsHandler(source mautrix.EventSource, evt *event.Event) {
+	var s roomSettings
+	if err := json.Unmarshal(evt.Content.VeryRaw, &s); err != nil {
+		log.Debug().Err(err).Str("room-id", evt.RoomID.String()).Msg("invalid room settings")
+		return
+	}
+
+	b.setRoomSettings(evt.RoomID, s)
+}
+
+// roomResponse shows or changes the room settings.
+// Usage: `!room`, `!room set <key> <value>` or `!room unset <key>`.
+func (b *Bot) roomResponse(ctx context.Context, u *user, evt *event.Event, msg string) error {
+	fields := strings.Fields(msg)
+	if len(fields) == 0 {
+		return b.markdownResponse(evt, false, formatRoomSettings(b.getRoomSettings(evt.RoomID)))
+	}
+
+	var key, value string
+	switch {
+	case fields[0] == "set" && len(fields) >= 3:
+		key = fields[1]
+		value = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(strings.TrimPrefix(msg, "set")), key))
+	case fields[0] == "unset" && len(fields) == 2:
+		key = fields[1]
+	default:
+		return b.markdownResponse(evt, true, roomUsageMsg)
+	}
+
+	apply, ok := roomSettingKeys[key]
+	if !ok {
+		return b.markdownResponse(evt, true, roomUsageMsg)
+	}
+
+	allowed, err := b.canChangeSettings(evt.RoomID, evt.Sender)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return b.markdownResponse(evt, true, roomForbiddenMsg)
+	}
+
+	if err := b.checkRoomSetting(key, value); err != nil {
+		return b.markdownResponse(evt, true, err.Error())
+	}
+
+	s := b.getRoomSettings(evt.RoomID)
+	if err := apply(&s, value); err != nil {
+		return b.markdownResponse(evt, true, err.Error())
+	}
+
+	if _, err := b.client.SendStateEvent(evt.RoomID, settingsEventType, "", &s); err != nil {
+		return err
+	}
+
+	b.setRoomSettings(evt.RoomID, s)
+	b.reactionResponse(evt, "✅")
+	return nil
+}
+
+// canChangeSettings reports whether the user has the power level required to send the settings state event.
+func (b *Bot) canChangeSettings(roomID id.RoomID, userID id.UserID) (bool, error) {
+	var pl event.PowerLevelsEventContent
+	if err := b.client.StateEvent(roomID, event.StatePowerLevels, "", &pl); err != nil {
+		return false, err
+	}
+
+	return pl.GetUserLevel(userID) >= pl.GetEventLevel(settingsEventType), nil
+}
+
+// isTriggered reports whether the bot should respond to the message according to the room trigger mode.
+func (b *Bot) isTriggered(evt *event.Event, s roomSettings) bool {
+	if s.Trigger != triggerMention {
+		return true
+	}
+
+	content := evt.Content.AsMessage()
+	if extractCommand(commandBody(content)) != "" {
+		return true
+	}
+
+	if content.Mentions != nil {
+		for _, userID := range content.Mentions.UserIDs {
+			if userID == b.client.UserID {
+				return true
+			}
+		}
+	}
+
+	body := strings.ToLower(content.Body)
+	return strings.Contains(body, strings.ToLower(b.client.UserID.String())) ||
+		(b.selfProfile.DisplayName != "" && strings.Contains(body, strings.ToLower(b.selfProfile.DisplayName)))
+}
+
+// formatRoomSettings returns a readable representation of the room settings.
+func formatRoomSettings(s roomSettings) string {
+	value := func(v string) string {
+		if v == "" {
+			return "default"
+		}
+		return "`" + v + "`"
+	}
+
+	historyLimit := ""
+	if s.HistoryLimit > 0 {
+		historyLimit = strconv.Itoa(s.HistoryLimit)
+	}
+
+	return fmt.Sprintf("**Room settings**\n- persona: %s\n- model: %s\n- trigger: %s\n- history_limit: %s\n- language: %s\n\n%s",
+		value(s.Persona), value(s.Model), value(s.Trigger), value(historyLimit), value(s.Language), roomUsageMsg)
+}
@@ -4,13 +4,45 @@ const (
 	helpMsg = `**Commands**
 - ` + "`!image[-natural/-vivid] [prompt]`" + `: Creates an image based on the provided prompt. The default style is "Natural".
 - ` + "`!reset [prompt]`" + `: Resets the user's history. If a prompt is provided after the reset command, the bot will generate a GPT response based on this prompt.
+- ` + "`!room [set <key> <value> | unset <key>]`" + `: Shows or changes the room settings (persona, model, trigger, history_limit, language). Changes require the power level to send room state.
+- ` + "`!schedule [<when> <prompt> | delete <id>]`" + `: Lists your scheduled prompts in the room, schedules a prompt, e.g. ` + "`!schedule \"every weekday 09:00\" summarize the news`" + `, or deletes one. The answer is posted to the room or thread where the prompt was scheduled.
+- ` + "`!remind <when> <text>`" + `: Reminds you in the room, e.g. ` + "`!remind in 2h check the deploy`" + `.
+- ` + "`!summarize [<N> | since <duration|HH:MM>]`" + `: Summarizes the last N messages of the room (100 by default), or the messages since the given time, e.g. ` + "`!summarize since 2h`" + `.
+- ` + "`!translate <lang> [text]`" + `: Translates the text, or the message you reply to, into the language, e.g. ` + "`!translate German good morning`" + `.
+- ` + "`!kb [add [name] | delete <name>]`" + `: Lists the knowledge base documents, adds the text file you reply to, or deletes a document. Relevant excerpts are added to answers with their sources. The knowledge base is shared by all rooms, only admins can add or delete documents.
+- ` + "`!remember <fact>`" + `: Remembers a fact about you for all future conversations, e.g. ` + "`!remember answer with Go examples`" + `.
+- ` + "`!memories`" + `: Lists the facts the bot remembers about you.
+- ` + "`!forget <id|all>`" + `: Forgets a fact, or all of them.
+- ` + "`!export [json|markdown]`" + `: Uploads your current conversation as a file.
+- ` + "`!import`" + `: Reply to a JSON export with this command to restore the conversation.
+- ` + "`!save <name>`" + `, ` + "`!load <name>`" + `, ` + "`!list`" + `, ` + "`!delete <name>`" + `: Saves your current conversation under a name, switches to a saved one, lists or deletes them.
+- ` + "`!set <param> [value]`" + `: Sets one of your generation parameters (temperature, top_p, max_tokens, presence_penalty, frequency_penalty, seed, stop), e.g. ` + "`!set temperature 0.2`" + `. Without a value, the parameter is reset.
+- ` + "`!settings`" + `: Shows the model, persona and generation parameters used for your messages in the room.
+- ` + "`!json <schema> <text>`" + `: Extracts data from the text as JSON matching a configured schema, e.g. ` + "`!json action_items <meeting notes>`" + `. Without arguments, lists the schemas.
 - ` + "`[prompt]`" + `: If only a prompt is provided, the bot will generate a GPT-based response related to that prompt.
 
 **Notes**
 - You can use short aliases for a command; for example, ` + "`!i`" + ` for ` + "`!image`" + `, or ` + "`!iv`" + ` for ` + "`!image-vivid`" + `.
-- To terminate the current processing, simply delete your message from the chat.
+- React to an answer with 👍 or 👎 to rate it. React to the last answer with 🔄 to regenerate it; 👎 also retries it with a different temperature. React to any message of the conversation with ✂️ to forget everything after it.
+- To terminate the current processing, simply delete your message from the chat. Queued messages (marked with ⏳) can be cancelled the same way.
+- Messages blocked by moderation get a 🚫 reaction, flagged ones a 🚩 reaction.
 - If there are any errors, the bot will respond with a ❌ reaction. Contact the administrator if this occurs.
 `
 	timeoutMsg        = "Timeout error. Please try again. If the issue persists, contact the administrator."
 	unknownCommandMsg = "Unknown command. Please use the `!help` command to access the available commands."
+	welcomeMsg        = "Hi! Send me a message and I will answer it. Here is what I can do:\n\n"
+	roomForbiddenMsg  = "You don't have the power level required to change the room settings."
+	roomUsageMsg      = "Usage: `!room set <key> <value>` or `!room unset <key>`. Keys: `persona`, `model`, `trigger` (`always` or `mention`), `history_limit`, `language`."
+	scheduleUsageMsg  = "Usage: `!schedule <when> <prompt>`, `!schedule delete <id>` or `!remind <when> <text>`. When: `in 2h`, `at 09:00`, `every 30m`, `every day 09:00`, `every weekday 09:00` or `every monday 09:00`. Times are in the time zone of the bot server."
+	summarizeUsageMsg = "Usage: `!summarize`, `!summarize <N>` or `!summarize since <duration|HH:MM>`, e.g. `!summarize 200` or `!summarize since 9:30`."
+	translateUsageMsg = "Usage: `!translate <lang> <text>`, or reply to a message with `!translate <lang>`."
+	kbUsageMsg        = "Usage: `!kb`, `!kb add [name]` as a reply to a text file, or `!kb delete <name>`."
+	kbTooLargeMsg     = "The file is too large for the knowledge base, documents can have up to 1 MiB."
+	memoryUsageMsg    = "Usage: `!remember <fact>`, `!memories` or `!forget <id|all>`."
+	exportUsageMsg    = "Usage: `!export [json|markdown]`, or reply to a JSON export with `!import`."
+	savedUsageMsg     = "Usage: `!save <name>`, `!load <name>`, `!list` or `!delete <name>`. Names are single words."
+	setUsageMsg       = "Usage: `!set <param> <value>` or `!set <param>` to reset it. Params: `temperature` (0-2), `top_p` (0-1), `max_tokens`, `presence_penalty` and `frequency_penalty` (-2-2), `seed`, `stop` (up to 4 sequences separated by `|`)."
+	jsonUsageMsg      = "Usage: `!json <schema> <text>`."
+	adminOnlyMsg      = "This command is only available to admins."
+	unencryptedMsg    = "This room is not encrypted. Please enable encryption or use an encrypted room to talk to the bot."
 )
@@ -0,0 +1,201 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mazzz1y/matrix-gpt/internal/gpt"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+const (
+	// summarizeDefaultCount is the number of messages summarized if no range is given.
+	summarizeDefaultCount = 100
+	// summarizeMaxCount is the maximum number of messages fetched for a summary.
+	summarizeMaxCount = 1000
+	// summarizePageSize is the number of events requested per page of room history.
+	summarizePageSize = 100
+)
+
+// summarizeFilter requests only messages and the membership of their senders.
+var summarizeFilter = &mautrix.FilterPart{
+	Types:           []event.Type{event.EventMessage, event.EventEncrypted},
+	LazyLoadMembers: true,
+}
+
+// summarizeResponse summarizes the recent messages of the room.
+// Usage: `!summarize`, `!summarize <N>` or `!summarize since <duration|HH:MM>`.
+func (b *Bot) summarizeResponse(ctx context.Context, u *user, evt *event.Event, msg string) error {
+	count, since, err := parseSummarizeRange(msg, time.Now())
+	if err != nil {
+		return b.markdownResponse(evt, true, summarizeUsageMsg)
+	}
+
+	lines, err := b.fetchTranscript(ctx, evt, count, since)
+	if err != nil {
+		return err
+	}
+	if len(lines) == 0 {
+		return b.markdownResponse(evt, true, "There is nothing to summarize.")
+	}
+
+	transcript, placeholders := b.redact(u, strings.Join(lines, "\n"))
+	if err := b.moderate(ctx, evt, moderationPrompt, transcript); err != nil {
+		return err
+	}
+
+	g, err := b.userGeneration(ctx, evt.Sender.String(), b.getRoomSettings(evt.RoomID))
+	if err != nil {
+		return err
+	}
+
+	summary, err := b.gptClient.Summarize(ctx, strings.Split(transcript, "\n"), gpt.CompletionOptions{Model: g.Model, Params: g.Params})
+	if err != nil {
+		return err
+	}
+
+	if err := b.moderate(ctx, evt, moderationAnswer, summary); err != nil {
+		return err
+	}
+
+	return b.markdownResponse(evt, false, placeholders.Restore(summary))
+}
+
+// fetchTranscript pages back through the room history before the event and returns up to count messages
+// sent after since as transcript lines, oldest first.
+func (b *Bot) fetchTranscript(ctx context.Context, evt *event.Event, count int, since time.Time) ([]string, error) {
+	names := make(map[id.UserID]string)
+	var lines []string
+
+	from := ""
+	for len(lines) < count {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		resp, err := b.client.Messages(evt.RoomID, from, "", mautrix.DirectionBackward, summarizeFilter, summarizePageSize)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, member := range resp.State {
+			if member.Type != event.StateMember || member.StateKey == nil {
+				continue
+			}
+			if err := member.Content.ParseRaw(member.Type); err == nil && member.Content.AsMember().Displayname != "" {
+				names[id.UserID(*member.StateKey)] = member.Content.AsMember().Displayname
+			}
+		}
+
+		for _, e := range resp.Chunk {
+			if !since.IsZero() && e.Timestamp < since.UnixMilli() {
+				return reverseLines(lines), nil
+			}
+			if e.ID == evt.ID {
+				continue
+			}
+
+			if line, ok := b.formatTranscriptLine(evt.RoomID, e, names); ok {
+				lines = append(lines, line)
+				if len(lines) == count {
+					break
+				}
+			}
+		}
+
+		if resp.End == "" || len(resp.Chunk) == 0 {
+			break
+		}
+		from = resp.End
+	}
+
+	return reverseLines(lines), nil
+}
+
+// formatTranscriptLine decrypts the event if needed and formats it as "[time] sender: text".
+// Events that are not readable messages, such as edits, commands or undecryptable messages, are skipped.
+func (b *Bot) formatTranscriptLine(roomID id.RoomID, evt *event.Event, names map[id.UserID]string) (string, bool) {
+	evt, err := b.decryptEvent(roomID, evt)
+	if err != nil || evt.Type != event.EventMessage {
+		return "", false
+	}
+
+	content := evt.Content.AsMessage()
+	if content.Body == "" || content.RelatesTo.GetReplaceID() != "" || extractCommand(content.Body) != "" {
+		return "", false
+	}
+
+	body := content.Body
+	switch content.MsgType {
+	case event.MsgText, event.MsgNotice:
+	case event.MsgEmote:
+		body = "* " + body
+	default:
+		body = fmt.Sprintf("[%s: %s]", strings.TrimPrefix(string(content.MsgType), "m."), body)
+	}
+
+	return fmt.Sprintf("[%s] %s: %s", time.UnixMilli(evt.Timestamp).Local().Format("2006-01-02 15:04"),
+		b.senderName(roomID, evt.Sender, names), body), true
+}
+
+// senderName returns the display name of the user in the room, falling back to the user ID.
+func (b *Bot) senderName(roomID id.RoomID, userID id.UserID, names map[id.UserID]string) string {
+	if name, ok := names[userID]; ok {
+		return name
+	}
+
+	if b.client.StateStore != nil {
+		if member, ok := b.client.StateStore.TryGetMember(roomID, userID); ok && member.Displayname != "" {
+			return member.Displayname
+		}
+	}
+
+	return userID.String()
+}
+
+// parseSummarizeRange parses the arguments of `!summarize` into a message count and an optional start time.
+func parseSummarizeRange(msg string, now time.Time) (int, time.Time, error) {
+	fields := strings.Fields(msg)
+	switch {
+	case len(fields) == 0:
+		return summarizeDefaultCount, time.Time{}, nil
+	case len(fields) == 1:
+		n, err := strconv.Atoi(fields[0])
+		if err != nil || n <= 0 {
+			return 0, time.Time{}, fmt.Errorf("invalid message count %q", fields[0])
+		}
+		if n > summarizeMaxCount {
+			n = summarizeMaxCount
+		}
+		return n, time.Time{}, nil
+	case len(fields) == 2 && fields[0] == "since":
+		if d, err := time.ParseDuration(fields[1]); err == nil && d > 0 {
+			return summarizeMaxCount, now.Add(-d), nil
+		}
+
+		clock, err := time.Parse("15:04", fields[1])
+		if err != nil {
+			return 0, time.Time{}, err
+		}
+		since := time.Date(now.Year(), now.Month(), now.Day(), clock.Hour(), clock.Minute(), 0, 0, now.Location())
+		if since.After(now) {
+			since = since.AddDate(0, 0, -1)
+		}
+		return summarizeMaxCount, since, nil
+	default:
+		return 0, time.Time{}, fmt.Errorf("invalid range %q", msg)
+	}
+}
+
+// reverseLines reverses the lines in place and returns them.
+func reverseLines(lines []string) []string {
+	for i, j := 0, len(lines)-1; i < j; i, j = i+1, j-1 {
+		lines[i], lines[j] = lines[j], lines[i]
+	}
+	return lines
+}
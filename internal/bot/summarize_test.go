@@ -0,0 +1,58 @@
+package bot
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSummarizeRange(t *testing.T) {
+	now := time.Date(2024, 5, 8, 10, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		msg       string
+		wantCount int
+		wantSince time.Time
+		wantErr   bool
+	}{
+		{msg: "", wantCount: summarizeDefaultCount},
+		{msg: "  ", wantCount: summarizeDefaultCount},
+		{msg: "50", wantCount: 50},
+		{msg: "1", wantCount: 1},
+		{msg: "5000", wantCount: summarizeMaxCount},
+		{msg: "since 2h", wantCount: summarizeMaxCount, wantSince: now.Add(-2 * time.Hour)},
+		{msg: "since 90m", wantCount: summarizeMaxCount, wantSince: now.Add(-90 * time.Minute)},
+		{msg: "since 09:00", wantCount: summarizeMaxCount, wantSince: time.Date(2024, 5, 8, 9, 0, 0, 0, time.UTC)},
+		{msg: "since 10:30", wantCount: summarizeMaxCount, wantSince: now},
+		// A time later than now refers to yesterday.
+		{msg: "since 18:00", wantCount: summarizeMaxCount, wantSince: time.Date(2024, 5, 7, 18, 0, 0, 0, time.UTC)},
+		{msg: "0", wantErr: true},
+		{msg: "-5", wantErr: true},
+		{msg: "ten", wantErr: true},
+		{msg: "since", wantErr: true},
+		{msg: "since -2h", wantErr: true},
+		{msg: "since 0s", wantErr: true},
+		{msg: "since yesterday", wantErr: true},
+		{msg: "since 25:00", wantErr: true},
+		{msg: "since 2h please", wantErr: true},
+		{msg: "last 2h", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			count, since, err := parseSummarizeRange(tt.msg, now)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseSummarizeRange(%q) = %d, %s, want an error", tt.msg, count, since)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if count != tt.wantCount || !since.Equal(tt.wantSince) {
+				t.Errorf("parseSummarizeRange(%q) = %d, %s, want %d, %s", tt.msg, count, since, tt.wantCount, tt.wantSince)
+			}
+		})
+	}
+}
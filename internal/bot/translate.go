@@ -0,0 +1,131 @@
+package bot
+
+import (
+	"context"
+	"strings"
+
+	"github.com/mazzz1y/matrix-gpt/internal/gpt"
+	"github.com/rs/zerolog/log"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/format"
+)
+
+// translateResponse translates the text, or the message replied to, into the language.
+// Usage: `!translate <lang> [text]`.
+func (b *Bot) translateResponse(ctx context.Context, u *user, evt *event.Event, msg string) error {
+	lang, text, _ := strings.Cut(msg, " ")
+	text = strings.TrimSpace(text)
+	if lang == "" {
+		return b.markdownResponse(evt, true, translateUsageMsg)
+	}
+
+	if text == "" {
+		replyTo := evt.Content.AsMessage().RelatesTo.GetReplyTo()
+		if replyTo == "" {
+			return b.markdownResponse(evt, true, translateUsageMsg)
+		}
+
+		replied, err := b.client.GetEvent(evt.RoomID, replyTo)
+		if err != nil {
+			return err
+		}
+		replied, err = b.decryptEvent(evt.RoomID, replied)
+		if err != nil {
+			return err
+		}
+		if replied.Type != event.EventMessage {
+			return b.markdownResponse(evt, true, translateUsageMsg)
+		}
+
+		content := replied.Content.AsMessage()
+		content.RemoveReplyFallback()
+		text = content.Body
+	}
+
+	text, placeholders := b.redact(u, text)
+	if err := b.moderate(ctx, evt, moderationPrompt, text); err != nil {
+		return err
+	}
+
+	g, err := b.userGeneration(ctx, evt.Sender.String(), b.getRoomSettings(evt.RoomID))
+	if err != nil {
+		return err
+	}
+
+	translation, err := b.gptClient.Translate(ctx, text, lang, gpt.CompletionOptions{Model: g.Model, Params: g.Params})
+	if err != nil {
+		return err
+	}
+
+	if err := b.moderate(ctx, evt, moderationAnswer, translation); err != nil {
+		return err
+	}
+
+	return b.markdownResponse(evt, true, placeholders.Restore(translation))
+}
+
+// autoTranslate replies in a thread with a translation of the message into the room language,
+// unless the message is already written in it.
+func (b *Bot) autoTranslate(evt *event.Event, lang string) {
+	l := log.With().
+		Str("event", "translate").
+		Str("user-id", evt.Sender.String()).
+		Logger()
+
+	content := evt.Content.AsMessage()
+	if content.MsgType != event.MsgText || content.RelatesTo.GetReplaceID() != "" {
+		return
+	}
+
+	if !b.beginRequest() {
+		return
+	}
+	go func() {
+		defer b.inFlight.Done()
+
+		ctx := b.reqCtx
+		if err := b.slots.acquire(ctx); err != nil {
+			return
+		}
+		defer b.slots.release()
+
+		// The sender may not be an allowed user, the placeholders are only needed for this translation.
+		text, placeholders := b.redact(nil, event.TrimReplyFallbackText(content.Body))
+		if err := b.moderate(ctx, evt, moderationPrompt, text); err != nil {
+			l.Debug().Err(err).Msg("translation skipped")
+			return
+		}
+
+		g, err := b.userGeneration(ctx, evt.Sender.String(), b.getRoomSettings(evt.RoomID))
+		if err != nil {
+			l.Err(err).Msg("translation error")
+			return
+		}
+
+		translation, err := b.gptClient.TranslateIfNeeded(ctx, text, lang, gpt.CompletionOptions{Model: g.Model, Params: g.Params})
+		if err != nil {
+			l.Err(err).Msg("translation error")
+			return
+		}
+		if translation == "" {
+			l.Debug().Msg("message is in the room language")
+			return
+		}
+
+		if err := b.moderate(ctx, evt, moderationAnswer, translation); err != nil {
+			l.Debug().Err(err).Msg("translation skipped")
+			return
+		}
+
+		threadID := content.RelatesTo.GetThreadParent()
+		if threadID == "" {
+			threadID = evt.ID
+		}
+
+		reply := format.RenderMarkdown(placeholders.Restore(translation), true, false)
+		reply.RelatesTo = (&event.RelatesTo{}).SetThread(threadID, evt.ID)
+		if _, err := b.client.SendMessageEvent(evt.RoomID, event.EventMessage, &reply); err != nil {
+			l.Err(err).Msg("translation error")
+		}
+	}()
+}
@@ -4,18 +4,29 @@ import (
 	"context"
 	"sync"
 	"time"
+
+	"github.com/mazzz1y/matrix-gpt/internal/redact"
+	"github.com/sashabaranov/go-openai"
 )
 
 // user represents a GPT user with a chat history and last message timestamp.
 type user struct {
 	sync.RWMutex
-	history   *historyManager
-	reqMutex  sync.Mutex
-	activeReq *request
-	lastMsg   time.Time
+	history  *historyManager
+	slots    semaphore
+	requests map[string]*request
+	lastMsg  time.Time
+
+	// turn serializes the updates of the history of the user, so parallel requests don't overwrite each other's
+	// changes. Completions only hold it while reading the history and adding their turn.
+	turn semaphore
+
+	// placeholders maps the values redacted from the messages of the user to restore them in the answers.
+	// It is replaced when the history is reset, so requests in progress restore with the mapping they redacted with.
+	placeholders *redact.Mapping
 }
 
-// request represents a real-time request from a user.
+// request represents a queued or active request from a user.
 // each request is given a unique ID for tracking and a cancel function to stop the request if needed.
 type request struct {
 	ID     string
@@ -38,13 +49,13 @@ func (u *user) updateLastMsgTime() {
 	u.lastMsg = time.Now()
 }
 
-// createRequestContext creates a new context for a request and stores it as the active request.
-func (u *user) createRequestContext(id string) *context.Context {
+// createRequestContext creates a new context derived from parent for a request and adds it to the user's requests.
+func (u *user) createRequestContext(parent context.Context, id string) *context.Context {
 	u.Lock()
 	defer u.Unlock()
 
-	ctx, cancel := context.WithCancel(context.Background())
-	u.activeReq = &request{
+	ctx, cancel := context.WithCancel(parent)
+	u.requests[id] = &request{
 		ID:     id,
 		cancel: cancel,
 	}
@@ -52,33 +63,78 @@ func (u *user) createRequestContext(id string) *context.Context {
 	return &ctx
 }
 
-// getActiveRequest gets the current active request ID.
-func (u *user) getActiveRequestID() (id string, exists bool) {
+// getQueueSize gets the number of queued and active requests.
+func (u *user) getQueueSize() int {
+	u.RLock()
+	defer u.RUnlock()
+
+	return len(u.requests)
+}
+
+// cancelRequestContext cancels the context of a queued or active request.
+// It reports whether the request was found.
+func (u *user) cancelRequestContext(id string) bool {
 	u.Lock()
 	defer u.Unlock()
 
-	if u.activeReq != nil {
-		return u.activeReq.ID, true
+	req, ok := u.requests[id]
+	if ok {
+		req.cancel()
+		delete(u.requests, id)
 	}
 
-	return "", false
+	return ok
 }
 
-// cancelRequestContext cancels the context of the request.
-func (u *user) cancelRequestContext(id string) {
-	u.Lock()
-	defer u.Unlock()
+// newGptUser creates a new GPT user instance with a given history size and number of concurrent requests.
+func newGptUser(historySize, concurrency int) *user {
+	return &user{
+		history:  newHistoryManager(historySize),
+		slots:    newSemaphore(concurrency),
+		requests: make(map[string]*request),
+		lastMsg:  time.Now(),
+		turn:     newSemaphore(1),
 
-	if u.activeReq != nil && u.activeReq.ID == id {
-		u.activeReq.cancel()
-		u.activeReq = nil
+		placeholders: redact.NewMapping(),
 	}
 }
 
-// newGptUser creates a new GPT user instance with a given history size.
-func newGptUser(historySize int) *user {
-	return &user{
-		history: newHistoryManager(historySize),
-		lastMsg: time.Now(),
+// lockTurn waits until the other updates of the history of the user are finished.
+// The returned function must be called once the history is updated.
+func (u *user) lockTurn(ctx context.Context) (func(), error) {
+	if err := u.turn.acquire(ctx); err != nil {
+		return nil, err
+	}
+	return u.turn.release, nil
+}
+
+// historySnapshot waits until the other conversation turns of the user replacing the history are finished and
+// returns the current history and its generation.
+func (u *user) historySnapshot(ctx context.Context) ([]openai.ChatCompletionMessage, int, error) {
+	unlock, err := u.lockTurn(ctx)
+	if err != nil {
+		return nil, 0, err
 	}
+	defer unlock()
+
+	history, generation := u.history.snapshot()
+	return history, generation, nil
+}
+
+// mapping returns the placeholders of the values redacted from the current history.
+func (u *user) mapping() *redact.Mapping {
+	u.RLock()
+	defer u.RUnlock()
+
+	return u.placeholders
+}
+
+// resetHistory clears the history and starts a new mapping for the placeholders of redacted values.
+// The caller must hold the turn lock.
+func (u *user) resetHistory() {
+	u.history.reset()
+
+	u.Lock()
+	defer u.Unlock()
+	u.placeholders = redact.NewMapping()
 }
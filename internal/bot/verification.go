@@ -0,0 +1,200 @@
+package bot
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/crypto"
+	"maunium.net/go/mautrix/crypto/ssss"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// setupCrossSigning signs the bot device with the account's cross-signing keys, so that clients show it as verified.
+// The private keys are fetched from secret storage with the recovery key. New keys are only generated if the server
+// has none for the account or a reset is requested, and the new recovery key is written to a file next to the database.
+func (b *Bot) setupCrossSigning(cfg Config) error {
+	mach := b.crypto.Machine()
+
+	exists, err := b.hasCrossSigningKeys()
+	if err != nil {
+		return err
+	}
+
+	if !exists || cfg.ResetCrossSigning {
+		if cfg.Password == "" {
+			log.Warn().Msg("set a password to generate cross-signing keys")
+			return nil
+		}
+
+		path := recoveryKeyPath(cfg.SQLitePath)
+		if _, err := os.Stat(path); err == nil {
+			return fmt.Errorf("recovery key file %s already exists, move it away to generate new keys", path)
+		}
+
+		newRecoveryKey, err := mach.GenerateAndUploadCrossSigningKeys(cfg.Password, "")
+		if err != nil {
+			return fmt.Errorf("failed to generate cross-signing keys: %w", err)
+		}
+		if err := saveRecoveryKey(path, newRecoveryKey); err != nil {
+			return err
+		}
+	} else {
+		if cfg.RecoveryKey == "" {
+			log.Warn().Msg("no recovery key configured, the bot device will not be cross-signed")
+			return nil
+		}
+
+		key, err := b.getSSSSKey(cfg.RecoveryKey)
+		if err != nil {
+			return err
+		}
+
+		if err := mach.FetchCrossSigningKeysFromSSSS(key); err != nil {
+			return fmt.Errorf("failed to fetch cross-signing keys: %w", err)
+		}
+	}
+
+	if err := mach.SignOwnDevice(mach.OwnIdentity()); err != nil {
+		return fmt.Errorf("failed to sign own device: %w", err)
+	}
+	if err := mach.SignOwnMasterKey(); err != nil {
+		return fmt.Errorf("failed to sign own master key: %w", err)
+	}
+
+	log.Info().Str("device-id", b.client.DeviceID.String()).Msg("device cross-signed")
+	return nil
+}
+
+// hasCrossSigningKeys reports whether the server has a master key for the bot account.
+func (b *Bot) hasCrossSigningKeys() (bool, error) {
+	resp, err := b.client.QueryKeys(&mautrix.ReqQueryKeys{
+		DeviceKeys: mautrix.DeviceKeysRequest{b.client.UserID: mautrix.DeviceIDList{}},
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to query cross-signing keys: %w", err)
+	}
+
+	masterKey, ok := resp.MasterKeys[b.client.UserID]
+	return ok && len(masterKey.Keys) > 0, nil
+}
+
+// recoveryKeyPath returns the path of the file a generated recovery key is written to.
+func recoveryKeyPath(sqlitePath string) string {
+	return sqlitePath + ".recovery-key"
+}
+
+// saveRecoveryKey writes a newly generated recovery key to a file readable only by the owner.
+// The key itself is never logged.
+func saveRecoveryKey(path, recoveryKey string) error {
+	if err := writeKeyFile(path, []byte(recoveryKey+"\n")); err != nil {
+		return fmt.Errorf("failed to write recovery key: %w", err)
+	}
+
+	log.Warn().Str("path", path).Msg("wrote the new recovery key, store it safely, pass it via --recovery-key and delete the file")
+	return nil
+}
+
+// getSSSSKey returns the default secret storage key unlocked with the recovery key.
+func (b *Bot) getSSSSKey(recoveryKey string) (*ssss.Key, error) {
+	_, keyData, err := b.crypto.Machine().SSSS.GetDefaultKeyData()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get secret storage key: %w", err)
+	}
+
+	key, err := keyData.VerifyRecoveryKey(recoveryKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid recovery key: %w", err)
+	}
+
+	return key, nil
+}
+
+// acceptVerificationFrom accepts SAS verification requests from allowed users only, and only if automatic
+// confirmation is enabled, since the bot can't compare the SAS with the other device itself.
+func (b *Bot) acceptVerificationFrom(_ string, device *id.Device, _ id.RoomID) (crypto.VerificationRequestResponse, crypto.VerificationHooks) {
+	if !b.autoConfirmSAS {
+		log.Debug().Str("user-id", device.UserID.String()).Msg("verification request rejected, automatic confirmation is disabled")
+		return crypto.RejectRequest, nil
+	}
+	if _, ok := b.users[device.UserID.String()]; !ok {
+		log.Debug().Str("user-id", device.UserID.String()).Msg("verification request rejected")
+		return crypto.RejectRequest, nil
+	}
+
+	log.Info().
+		Str("user-id", device.UserID.String()).
+		Str("device-id", device.DeviceID.String()).
+		Msg("verification request accepted")
+
+	return crypto.AcceptRequest, &sasHooks{device: device}
+}
+
+// verificationHandler passes in-room verification events to the crypto machine.
+// Without encryption (appservice mode), verification requests are ignored.
+func (b *Bot) verificationHandler(source mautrix.EventSource, evt *event.Event) {
+	if b.crypto == nil {
+		return
+	}
+
+	if err := b.crypto.Machine().ProcessInRoomVerification(evt); err != nil {
+		log.Debug().Err(err).Str("user-id", evt.Sender.String()).Msg("in-room verification error")
+	}
+}
+
+// sasHooks confirms SAS verifications with allowed users.
+// The bot can't compare the SAS with the other device itself, so it is logged for admins and confirmed automatically.
+// This trusts whoever controls the allowed user's account, which is why it requires Config.AutoConfirmSAS.
+type sasHooks struct {
+	device *id.Device
+}
+
+func (h *sasHooks) VerifySASMatch(device *id.Device, sas crypto.SASData) bool {
+	log.Info().
+		Str("user-id", device.UserID.String()).
+		Str("device-id", device.DeviceID.String()).
+		Str("sas", formatSAS(sas)).
+		Msg("confirming verification")
+	return true
+}
+
+func (h *sasHooks) VerificationMethods() []crypto.VerificationMethod {
+	return []crypto.VerificationMethod{
+		crypto.VerificationMethodEmoji{},
+		crypto.VerificationMethodDecimal{},
+	}
+}
+
+func (h *sasHooks) OnCancel(cancelledByUs bool, reason string, reasonCode event.VerificationCancelCode) {
+	log.Info().
+		Str("user-id", h.device.UserID.String()).
+		Bool("cancelled-by-us", cancelledByUs).
+		Str("reason", reason).
+		Msg("verification cancelled")
+}
+
+func (h *sasHooks) OnSuccess() {
+	log.Info().
+		Str("user-id", h.device.UserID.String()).
+		Str("device-id", h.device.DeviceID.String()).
+		Msg("verification succeeded")
+}
+
+// formatSAS returns a readable representation of the SAS emojis or numbers.
+func formatSAS(sas crypto.SASData) string {
+	switch s := sas.(type) {
+	case crypto.EmojiSASData:
+		parts := make([]string, len(s))
+		for i, e := range s {
+			parts[i] = fmt.Sprintf("%c %s", e.Emoji, e.Description)
+		}
+		return strings.Join(parts, ", ")
+	case crypto.DecimalSASData:
+		return fmt.Sprintf("%d %d %d", s[0], s[1], s[2])
+	default:
+		return fmt.Sprint(sas)
+	}
+}
@@ -7,15 +7,40 @@ import (
 	"github.com/sashabaranov/go-openai"
 )
 
+// CompletionOptions overrides the defaults of a single completion request.
+type CompletionOptions struct {
+	// Model replaces the configured model if set.
+	Model string
+	// SystemPrompt is sent before the history if set. It is not part of the returned history.
+	SystemPrompt string
+	// SaveMemory, if set, lets the model save facts about the user with a tool call.
+	SaveMemory func(fact string) error
+	// Params are the generation parameters of the request.
+	Params Params
+}
+
 // CreateCompletion retrieves a completion from GPT using the given user's message.
-func (g *Gpt) CreateCompletion(ctx context.Context, history []openai.ChatCompletionMessage, userMsg string) ([]openai.ChatCompletionMessage, error) {
+func (g *Gpt) CreateCompletion(ctx context.Context, history []openai.ChatCompletionMessage, userMsg string, opts CompletionOptions) ([]openai.ChatCompletionMessage, error) {
 	// Append the user's message to the existing history.
 	messageHistory := append(history, openai.ChatCompletionMessage{
 		Role:    openai.ChatMessageRoleUser,
 		Content: userMsg,
 	})
 
-	res, err := g.complReqWithTimeout(ctx, messageHistory)
+	reqMessages := messageHistory
+	if opts.SystemPrompt != "" {
+		reqMessages = append([]openai.ChatCompletionMessage{{
+			Role:    openai.ChatMessageRoleSystem,
+			Content: opts.SystemPrompt,
+		}}, messageHistory...)
+	}
+
+	model := g.model
+	if opts.Model != "" {
+		model = opts.Model
+	}
+
+	res, err := g.complReqWithTools(ctx, model, reqMessages, opts)
 	if err != nil {
 		return []openai.ChatCompletionMessage{}, err
 	}
@@ -26,8 +51,48 @@ func (g *Gpt) CreateCompletion(ctx context.Context, history []openai.ChatComplet
 	}), err
 }
 
+// complReqWithTools makes completion requests until the model answers without calling a tool.
+// The tool calls and their results are not part of the returned answer or the history.
+func (g *Gpt) complReqWithTools(ctx context.Context, model string, msg []openai.ChatCompletionMessage, opts CompletionOptions) (string, error) {
+	req := openai.ChatCompletionRequest{Model: model, Messages: msg}
+	opts.Params.apply(&req)
+	if opts.SaveMemory != nil {
+		req.Tools = []openai.Tool{saveMemoryTool}
+	}
+
+	for i := 0; ; i++ {
+		if i == maxToolRounds {
+			// Force an answer if the model keeps calling tools.
+			req.Tools = nil
+		}
+
+		res, err := g.complReq(ctx, req)
+		if err != nil || len(res.ToolCalls) == 0 {
+			return res.Content, err
+		}
+
+		req.Messages = append(req.Messages, res)
+		for _, call := range res.ToolCalls {
+			req.Messages = append(req.Messages, openai.ChatCompletionMessage{
+				Role:       openai.ChatMessageRoleTool,
+				Content:    callTool(call, opts),
+				ToolCallID: call.ID,
+			})
+		}
+	}
+}
+
 // complReqWithTimeout makes a request to get a GPT completion with a specified timeout.
-func (g *Gpt) complReqWithTimeout(ctx context.Context, msg []openai.ChatCompletionMessage) (string, error) {
+func (g *Gpt) complReqWithTimeout(ctx context.Context, model string, msg []openai.ChatCompletionMessage, params Params) (string, error) {
+	req := openai.ChatCompletionRequest{Model: model, Messages: msg}
+	params.apply(&req)
+
+	res, err := g.complReq(ctx, req)
+	return res.Content, err
+}
+
+// complReq makes a completion request with a timeout per attempt and returns the answer message.
+func (g *Gpt) complReq(ctx context.Context, req openai.ChatCompletionRequest) (openai.ChatCompletionMessage, error) {
 	var res openai.ChatCompletionResponse
 	var err error
 
@@ -35,18 +100,12 @@ func (g *Gpt) complReqWithTimeout(ctx context.Context, msg []openai.ChatCompleti
 		ctx, cancel := context.WithTimeout(ctx, g.gptTimeout)
 		defer cancel()
 
-		res, err = g.client.CreateChatCompletion(
-			ctx,
-			openai.ChatCompletionRequest{
-				Model:    g.model,
-				Messages: msg,
-			},
-		)
+		res, err = g.client.CreateChatCompletion(ctx, req)
 
 		if ctx.Err() == context.Canceled {
-			return "", ctx.Err()
+			return openai.ChatCompletionMessage{}, ctx.Err()
 		} else if isTokenExceededError(err) {
-			msg = trimFirstMsgFromHistory(msg)
+			req.Messages = trimFirstMsgFromHistory(req.Messages)
 		} else if !isServiceUnavailableError(err) && len(res.Choices) > 0 {
 			break
 		}
@@ -55,15 +114,15 @@ func (g *Gpt) complReqWithTimeout(ctx context.Context, msg []openai.ChatCompleti
 	}
 
 	if len(res.Choices) < 1 {
-		return "", errors.New("empty response")
+		return openai.ChatCompletionMessage{}, errors.New("empty response")
 	}
 
-	return res.Choices[0].Message.Content, err
+	return res.Choices[0].Message, err
 }
 
 func trimFirstMsgFromHistory(msg []openai.ChatCompletionMessage) []openai.ChatCompletionMessage {
 	for i, m := range msg {
-		if m.Role != "ChatMessageRoleSystem" {
+		if m.Role != openai.ChatMessageRoleSystem {
 			return append(msg[:i], msg[i+1:]...)
 		}
 	}
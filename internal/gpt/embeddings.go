@@ -0,0 +1,70 @@
+package gpt
+
+import (
+	"context"
+	"errors"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// embeddingBatchSize is the maximum number of texts sent in a single embeddings request.
+const embeddingBatchSize = 100
+
+// CreateEmbeddings returns the embedding vectors of the texts, in the same order.
+func (g *Gpt) CreateEmbeddings(ctx context.Context, texts []string) ([][]float32, error) {
+	vectors := make([][]float32, 0, len(texts))
+	for start := 0; start < len(texts); start += embeddingBatchSize {
+		end := start + embeddingBatchSize
+		if end > len(texts) {
+			end = len(texts)
+		}
+
+		batch, err := g.embeddingsReqWithTimeout(ctx, texts[start:end])
+		if err != nil {
+			return nil, err
+		}
+		vectors = append(vectors, batch...)
+	}
+
+	return vectors, nil
+}
+
+// embeddingsReqWithTimeout requests the embeddings of a batch of texts, retrying on unavailable service.
+func (g *Gpt) embeddingsReqWithTimeout(ctx context.Context, texts []string) ([][]float32, error) {
+	var res openai.EmbeddingResponse
+	var err error
+
+	for i := 0; i < g.maxAttempts; i++ {
+		reqCtx, cancel := context.WithTimeout(ctx, g.gptTimeout)
+		res, err = g.client.CreateEmbeddings(reqCtx, openai.EmbeddingRequestStrings{
+			Input: texts,
+			Model: openai.AdaEmbeddingV2,
+		})
+		cancel()
+
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		} else if err == nil || !isServiceUnavailableError(err) {
+			break
+		}
+
+		sleepBeforeRetry(i)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(res.Data) != len(texts) {
+		return nil, errors.New("unexpected number of embeddings")
+	}
+
+	vectors := make([][]float32, len(texts))
+	for _, e := range res.Data {
+		if e.Index < 0 || e.Index >= len(vectors) {
+			return nil, errors.New("unexpected embedding index")
+		}
+		vectors[e.Index] = e.Embedding
+	}
+
+	return vectors, nil
+}
@@ -1,6 +1,7 @@
 package gpt
 
 import (
+	"context"
 	"time"
 
 	"github.com/sashabaranov/go-openai"
@@ -32,3 +33,12 @@ func (g *Gpt) GetModel() string {
 func (g *Gpt) GetTimeout() time.Duration {
 	return g.gptTimeout
 }
+
+// Ping checks that the OpenAI API is reachable and the configured model is available.
+func (g *Gpt) Ping(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, g.gptTimeout)
+	defer cancel()
+
+	_, err := g.client.GetModel(ctx, g.model)
+	return err
+}
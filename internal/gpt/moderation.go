@@ -0,0 +1,81 @@
+package gpt
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// ModerationCategories are the categories scored by the moderation endpoint.
+var ModerationCategories = []string{
+	"hate", "hate/threatening", "self-harm", "sexual", "sexual/minors", "violence", "violence/graphic",
+}
+
+// Moderation is the result of a moderation request.
+type Moderation struct {
+	// Flagged lists the categories flagged by the moderation endpoint itself.
+	Flagged []string
+	// Scores are the scores between 0 and 1 of all categories.
+	Scores map[string]float32
+}
+
+// Moderate checks the text with the moderation endpoint, retrying on unavailable service.
+func (g *Gpt) Moderate(ctx context.Context, text string) (Moderation, error) {
+	var res openai.ModerationResponse
+	var err error
+
+	for i := 0; i < g.maxAttempts; i++ {
+		reqCtx, cancel := context.WithTimeout(ctx, g.gptTimeout)
+		res, err = g.client.Moderations(reqCtx, openai.ModerationRequest{Input: text})
+		cancel()
+
+		if ctx.Err() != nil {
+			return Moderation{}, ctx.Err()
+		} else if err == nil || !isServiceUnavailableError(err) {
+			break
+		}
+
+		sleepBeforeRetry(i)
+	}
+	if err != nil {
+		return Moderation{}, err
+	}
+	if len(res.Results) < 1 {
+		return Moderation{}, errors.New("empty moderation response")
+	}
+
+	return toModeration(res.Results[0])
+}
+
+// toModeration converts the result to category maps using the JSON names of the categories.
+func toModeration(r openai.Result) (Moderation, error) {
+	var m Moderation
+
+	scores, err := json.Marshal(r.CategoryScores)
+	if err != nil {
+		return m, err
+	}
+	if err := json.Unmarshal(scores, &m.Scores); err != nil {
+		return m, err
+	}
+
+	categories, err := json.Marshal(r.Categories)
+	if err != nil {
+		return m, err
+	}
+	var flagged map[string]bool
+	if err := json.Unmarshal(categories, &flagged); err != nil {
+		return m, err
+	}
+	for c, ok := range flagged {
+		if ok {
+			m.Flagged = append(m.Flagged, c)
+		}
+	}
+	sort.Strings(m.Flagged)
+
+	return m, nil
+}
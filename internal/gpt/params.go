@@ -0,0 +1,196 @@
+package gpt
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// maxStopSequences is the maximum number of stop sequences accepted by the API.
+const maxStopSequences = 4
+
+// Params are the generation parameters of a completion request. Unset fields use the API defaults.
+type Params struct {
+	Temperature      *float32 `json:"temperature,omitempty" yaml:"temperature,omitempty"`
+	TopP             *float32 `json:"top_p,omitempty" yaml:"top_p,omitempty"`
+	MaxTokens        *int     `json:"max_tokens,omitempty" yaml:"max_tokens,omitempty"`
+	PresencePenalty  *float32 `json:"presence_penalty,omitempty" yaml:"presence_penalty,omitempty"`
+	FrequencyPenalty *float32 `json:"frequency_penalty,omitempty" yaml:"frequency_penalty,omitempty"`
+	Seed             *int     `json:"seed,omitempty" yaml:"seed,omitempty"`
+	Stop             []string `json:"stop,omitempty" yaml:"stop,omitempty"`
+}
+
+// ParamNames lists the names of the parameters accepted by Set, in display order.
+var ParamNames = []string{"temperature", "top_p", "max_tokens", "presence_penalty", "frequency_penalty", "seed", "stop"}
+
+// Merge returns the parameters with the fields set in override replaced.
+func (p Params) Merge(override Params) Params {
+	if override.Temperature != nil {
+		p.Temperature = override.Temperature
+	}
+	if override.TopP != nil {
+		p.TopP = override.TopP
+	}
+	if override.MaxTokens != nil {
+		p.MaxTokens = override.MaxTokens
+	}
+	if override.PresencePenalty != nil {
+		p.PresencePenalty = override.PresencePenalty
+	}
+	if override.FrequencyPenalty != nil {
+		p.FrequencyPenalty = override.FrequencyPenalty
+	}
+	if override.Seed != nil {
+		p.Seed = override.Seed
+	}
+	if override.Stop != nil {
+		p.Stop = override.Stop
+	}
+	return p
+}
+
+// Validate checks that the parameters are within the ranges accepted by the API.
+func (p Params) Validate() error {
+	switch {
+	case p.Temperature != nil && (*p.Temperature < 0 || *p.Temperature > 2):
+		return errors.New("temperature must be between 0 and 2")
+	case p.TopP != nil && (*p.TopP < 0 || *p.TopP > 1):
+		return errors.New("top_p must be between 0 and 1")
+	case p.MaxTokens != nil && *p.MaxTokens < 1:
+		return errors.New("max_tokens must be positive")
+	case p.PresencePenalty != nil && (*p.PresencePenalty < -2 || *p.PresencePenalty > 2):
+		return errors.New("presence_penalty must be between -2 and 2")
+	case p.FrequencyPenalty != nil && (*p.FrequencyPenalty < -2 || *p.FrequencyPenalty > 2):
+		return errors.New("frequency_penalty must be between -2 and 2")
+	case len(p.Stop) > maxStopSequences:
+		return fmt.Errorf("at most %d stop sequences are allowed", maxStopSequences)
+	}
+	return nil
+}
+
+// Set parses and sets the parameter by name. An empty value unsets it.
+// Stop sequences are separated by "|".
+func (p *Params) Set(name, value string) error {
+	var err error
+	switch name {
+	case "temperature":
+		p.Temperature, err = parseFloatParam(value)
+	case "top_p":
+		p.TopP, err = parseFloatParam(value)
+	case "max_tokens":
+		p.MaxTokens, err = parseIntParam(value)
+	case "presence_penalty":
+		p.PresencePenalty, err = parseFloatParam(value)
+	case "frequency_penalty":
+		p.FrequencyPenalty, err = parseFloatParam(value)
+	case "seed":
+		p.Seed, err = parseIntParam(value)
+	case "stop":
+		p.Stop = nil
+		if value != "" {
+			p.Stop = strings.Split(value, "|")
+		}
+	default:
+		return fmt.Errorf("unknown parameter %q", name)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid value for %s: %q", name, value)
+	}
+
+	return p.Validate()
+}
+
+// Get returns the value of the parameter by name, or an empty string if it is not set.
+func (p Params) Get(name string) string {
+	formatFloat := func(f *float32) string {
+		if f == nil {
+			return ""
+		}
+		return strconv.FormatFloat(float64(*f), 'g', -1, 32)
+	}
+	formatInt := func(i *int) string {
+		if i == nil {
+			return ""
+		}
+		return strconv.Itoa(*i)
+	}
+
+	switch name {
+	case "temperature":
+		return formatFloat(p.Temperature)
+	case "top_p":
+		return formatFloat(p.TopP)
+	case "max_tokens":
+		return formatInt(p.MaxTokens)
+	case "presence_penalty":
+		return formatFloat(p.PresencePenalty)
+	case "frequency_penalty":
+		return formatFloat(p.FrequencyPenalty)
+	case "seed":
+		return formatInt(p.Seed)
+	case "stop":
+		return strings.Join(p.Stop, "|")
+	default:
+		return ""
+	}
+}
+
+// apply sets the parameters on the request.
+func (p Params) apply(req *openai.ChatCompletionRequest) {
+	if p.Temperature != nil {
+		req.Temperature = *p.Temperature
+		if req.Temperature == 0 {
+			// A zero temperature would be omitted from the request, use the smallest positive one instead.
+			req.Temperature = math.SmallestNonzeroFloat32
+		}
+	}
+	if p.TopP != nil {
+		req.TopP = *p.TopP
+		if req.TopP == 0 {
+			req.TopP = math.SmallestNonzeroFloat32
+		}
+	}
+	if p.MaxTokens != nil {
+		req.MaxTokens = *p.MaxTokens
+	}
+	if p.PresencePenalty != nil {
+		req.PresencePenalty = *p.PresencePenalty
+	}
+	if p.FrequencyPenalty != nil {
+		req.FrequencyPenalty = *p.FrequencyPenalty
+	}
+	req.Seed = p.Seed
+	req.Stop = p.Stop
+}
+
+// parseFloatParam parses a float parameter, returning nil for an empty value.
+func parseFloatParam(value string) (*float32, error) {
+	if value == "" {
+		return nil, nil
+	}
+	f, err := strconv.ParseFloat(value, 32)
+	if err != nil {
+		return nil, err
+	}
+	if math.IsNaN(f) {
+		return nil, errors.New("not a number")
+	}
+	v := float32(f)
+	return &v, nil
+}
+
+// parseIntParam parses an integer parameter, returning nil for an empty value.
+func parseIntParam(value string) (*int, error) {
+	if value == "" {
+		return nil, nil
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		return nil, err
+	}
+	return &i, nil
+}
@@ -0,0 +1,149 @@
+package gpt
+
+import (
+	"reflect"
+	"testing"
+)
+
+func float32Ptr(f float32) *float32 { return &f }
+
+func intPtr(i int) *int { return &i }
+
+func TestParamsSet(t *testing.T) {
+	tests := []struct {
+		name    string
+		param   string
+		value   string
+		want    Params
+		wantErr bool
+	}{
+		{name: "temperature", param: "temperature", value: "0.7", want: Params{Temperature: float32Ptr(0.7)}},
+		{name: "temperature zero", param: "temperature", value: "0", want: Params{Temperature: float32Ptr(0)}},
+		{name: "temperature max", param: "temperature", value: "2", want: Params{Temperature: float32Ptr(2)}},
+		{name: "top_p", param: "top_p", value: "1", want: Params{TopP: float32Ptr(1)}},
+		{name: "max_tokens", param: "max_tokens", value: "1500", want: Params{MaxTokens: intPtr(1500)}},
+		{name: "presence_penalty", param: "presence_penalty", value: "-2", want: Params{PresencePenalty: float32Ptr(-2)}},
+		{name: "frequency_penalty", param: "frequency_penalty", value: "1.5", want: Params{FrequencyPenalty: float32Ptr(1.5)}},
+		{name: "seed", param: "seed", value: "42", want: Params{Seed: intPtr(42)}},
+		{name: "seed negative", param: "seed", value: "-1", want: Params{Seed: intPtr(-1)}},
+		{name: "stop", param: "stop", value: "END|---", want: Params{Stop: []string{"END", "---"}}},
+		{name: "stop max", param: "stop", value: "a|b|c|d", want: Params{Stop: []string{"a", "b", "c", "d"}}},
+		{name: "temperature too high", param: "temperature", value: "2.1", wantErr: true},
+		{name: "temperature negative", param: "temperature", value: "-0.1", wantErr: true},
+		{name: "temperature NaN", param: "temperature", value: "NaN", wantErr: true},
+		{name: "temperature infinite", param: "temperature", value: "Inf", wantErr: true},
+		{name: "temperature not a number", param: "temperature", value: "warm", wantErr: true},
+		{name: "top_p too high", param: "top_p", value: "1.01", wantErr: true},
+		{name: "max_tokens zero", param: "max_tokens", value: "0", wantErr: true},
+		{name: "max_tokens fraction", param: "max_tokens", value: "1.5", wantErr: true},
+		{name: "presence_penalty too low", param: "presence_penalty", value: "-2.5", wantErr: true},
+		{name: "frequency_penalty too high", param: "frequency_penalty", value: "3", wantErr: true},
+		{name: "seed not a number", param: "seed", value: "abc", wantErr: true},
+		{name: "too many stop sequences", param: "stop", value: "a|b|c|d|e", wantErr: true},
+		{name: "unknown parameter", param: "top_k", value: "5", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var p Params
+			err := p.Set(tt.param, tt.value)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Set(%q, %q) succeeded with %+v, want an error", tt.param, tt.value, p)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(p, tt.want) {
+				t.Errorf("Set(%q, %q) = %+v, want %+v", tt.param, tt.value, p, tt.want)
+			}
+			if got := p.Get(tt.param); got != tt.value {
+				t.Errorf("Get(%q) = %q, want %q", tt.param, got, tt.value)
+			}
+		})
+	}
+}
+
+func TestParamsUnset(t *testing.T) {
+	p := Params{
+		Temperature:      float32Ptr(1),
+		TopP:             float32Ptr(0.5),
+		MaxTokens:        intPtr(100),
+		PresencePenalty:  float32Ptr(1),
+		FrequencyPenalty: float32Ptr(1),
+		Seed:             intPtr(7),
+		Stop:             []string{"END"},
+	}
+
+	for _, name := range ParamNames {
+		if err := p.Set(name, ""); err != nil {
+			t.Errorf("Set(%q, \"\") error = %v", name, err)
+		}
+		if got := p.Get(name); got != "" {
+			t.Errorf("Get(%q) after unset = %q, want empty", name, got)
+		}
+	}
+
+	if !reflect.DeepEqual(p, Params{}) {
+		t.Errorf("params after unsetting all = %+v, want none set", p)
+	}
+}
+
+func TestParamsValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		params  Params
+		wantErr bool
+	}{
+		{"empty", Params{}, false},
+		{"bounds", Params{Temperature: float32Ptr(0), TopP: float32Ptr(0), MaxTokens: intPtr(1), PresencePenalty: float32Ptr(2), FrequencyPenalty: float32Ptr(-2)}, false},
+		{"temperature", Params{Temperature: float32Ptr(3)}, true},
+		{"top_p", Params{TopP: float32Ptr(-0.5)}, true},
+		{"max_tokens", Params{MaxTokens: intPtr(-1)}, true},
+		{"presence_penalty", Params{PresencePenalty: float32Ptr(2.5)}, true},
+		{"frequency_penalty", Params{FrequencyPenalty: float32Ptr(-3)}, true},
+		{"stop", Params{Stop: []string{"1", "2", "3", "4", "5"}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.params.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParamsMerge(t *testing.T) {
+	defaults := Params{Temperature: float32Ptr(0.2), MaxTokens: intPtr(1500), Stop: []string{"END"}}
+	persona := Params{Temperature: float32Ptr(0.5), TopP: float32Ptr(0.9)}
+	user := Params{Temperature: float32Ptr(1.2), Seed: intPtr(42)}
+
+	got := defaults.Merge(persona).Merge(user)
+	want := Params{
+		Temperature: float32Ptr(1.2),
+		TopP:        float32Ptr(0.9),
+		MaxTokens:   intPtr(1500),
+		Seed:        intPtr(42),
+		Stop:        []string{"END"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Merge() = %+v, want %+v", got, want)
+	}
+
+	// Unset fields of the override keep the values, the receiver is not modified.
+	if got := defaults.Merge(Params{}); !reflect.DeepEqual(got, defaults) {
+		t.Errorf("Merge(Params{}) = %+v, want %+v", got, defaults)
+	}
+	if *defaults.Temperature != 0.2 {
+		t.Errorf("Merge() modified the receiver")
+	}
+
+	// An empty, non-nil stop list overrides the stop sequences.
+	if got := defaults.Merge(Params{Stop: []string{}}); got.Stop == nil || len(got.Stop) != 0 {
+		t.Errorf("Merge() with empty stop = %v, want an empty list", got.Stop)
+	}
+}
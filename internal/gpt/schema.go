@@ -0,0 +1,156 @@
+package gpt
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// schemaTypes are the JSON Schema types supported by Schema.
+var schemaTypes = map[string]bool{
+	"object": true, "array": true, "string": true, "number": true, "integer": true, "boolean": true, "null": true,
+}
+
+// Schema is the subset of JSON Schema used to validate structured answers: type, properties, required,
+// additionalProperties, items and enum.
+type Schema struct {
+	Type                 string             `json:"type,omitempty" yaml:"type"`
+	Description          string             `json:"description,omitempty" yaml:"description"`
+	Properties           map[string]*Schema `json:"properties,omitempty" yaml:"properties"`
+	Required             []string           `json:"required,omitempty" yaml:"required"`
+	AdditionalProperties *bool              `json:"additionalProperties,omitempty" yaml:"additionalProperties"`
+	Items                *Schema            `json:"items,omitempty" yaml:"items"`
+	Enum                 []any              `json:"enum,omitempty" yaml:"enum"`
+}
+
+// Check reports an error if the schema uses an unsupported type or has required properties that are not defined.
+func (s *Schema) Check() error {
+	return s.check("$")
+}
+
+func (s *Schema) check(path string) error {
+	if s.Type != "" && !schemaTypes[s.Type] {
+		return fmt.Errorf("%s: unsupported type %q", path, s.Type)
+	}
+	for _, name := range s.Required {
+		if _, ok := s.Properties[name]; !ok {
+			return fmt.Errorf("%s: required property %q is not defined", path, name)
+		}
+	}
+	for name, p := range s.Properties {
+		if p == nil {
+			return fmt.Errorf("%s.%s: empty schema", path, name)
+		}
+		if err := p.check(path + "." + name); err != nil {
+			return err
+		}
+	}
+	if s.Items != nil {
+		return s.Items.check(path + "[]")
+	}
+	return nil
+}
+
+// Validate reports the first mismatch between the decoded JSON value and the schema.
+func (s *Schema) Validate(v any) error {
+	return s.validate(v, "$")
+}
+
+func (s *Schema) validate(v any, path string) error {
+	if len(s.Enum) > 0 && !enumContains(s.Enum, v) {
+		return fmt.Errorf("%s: value is not one of the allowed values", path)
+	}
+
+	switch s.Type {
+	case "":
+		return nil
+	case "object":
+		obj, ok := v.(map[string]any)
+		if !ok {
+			return fmt.Errorf("%s: expected an object", path)
+		}
+		return s.validateObject(obj, path)
+	case "array":
+		arr, ok := v.([]any)
+		if !ok {
+			return fmt.Errorf("%s: expected an array", path)
+		}
+		if s.Items == nil {
+			return nil
+		}
+		for i, item := range arr {
+			if err := s.Items.validate(item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "string":
+		if _, ok := v.(string); !ok {
+			return fmt.Errorf("%s: expected a string", path)
+		}
+	case "number":
+		if _, ok := v.(float64); !ok {
+			return fmt.Errorf("%s: expected a number", path)
+		}
+	case "integer":
+		if f, ok := v.(float64); !ok || f != math.Trunc(f) {
+			return fmt.Errorf("%s: expected an integer", path)
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			return fmt.Errorf("%s: expected a boolean", path)
+		}
+	case "null":
+		if v != nil {
+			return fmt.Errorf("%s: expected null", path)
+		}
+	}
+	return nil
+}
+
+// validateObject checks the required and the defined properties of the object.
+func (s *Schema) validateObject(obj map[string]any, path string) error {
+	for _, name := range s.Required {
+		if _, ok := obj[name]; !ok {
+			return fmt.Errorf("%s: missing required property %q", path, name)
+		}
+	}
+
+	// Sort the keys so the reported error doesn't depend on the map order.
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		p, ok := s.Properties[k]
+		if !ok {
+			if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+				return fmt.Errorf("%s: unexpected property %q", path, k)
+			}
+			continue
+		}
+		if err := p.validate(obj[k], path+"."+k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// enumContains reports whether the value is one of the enum values, comparing their JSON representations
+// since the enum may be decoded from YAML with different Go types.
+func enumContains(enum []any, v any) bool {
+	want, err := json.Marshal(v)
+	if err != nil {
+		return false
+	}
+	for _, e := range enum {
+		got, err := json.Marshal(e)
+		if err == nil && string(got) == string(want) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,147 @@
+package gpt
+
+import (
+	"encoding/json"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func mustSchema(t *testing.T, src string) *Schema {
+	t.Helper()
+	var s Schema
+	if err := yaml.Unmarshal([]byte(src), &s); err != nil {
+		t.Fatal(err)
+	}
+	return &s
+}
+
+func TestSchemaCheck(t *testing.T) {
+	tests := []struct {
+		name    string
+		schema  string
+		wantErr bool
+	}{
+		{"empty", `{}`, false},
+		{"object", "type: object\nproperties:\n  a: {type: string}\nrequired: [a]", false},
+		{"nested", "type: array\nitems:\n  type: object\n  properties:\n    b: {type: integer}\n  required: [b]", false},
+		{"unsupported type", `type: date`, true},
+		{"nested unsupported type", "type: object\nproperties:\n  a: {type: float}", true},
+		{"required not defined", "type: object\nproperties:\n  a: {type: string}\nrequired: [b]", true},
+		{"required without properties", "type: object\nrequired: [a]", true},
+		{"nested required without properties", "type: array\nitems:\n  type: object\n  required: [a]", true},
+		{"empty property", "type: object\nproperties:\n  a:", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := mustSchema(t, tt.schema).Check()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Check() error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSchemaValidate(t *testing.T) {
+	schema := mustSchema(t, `
+type: object
+properties:
+  name: {type: string}
+  count: {type: integer}
+  score: {type: number}
+  done: {type: boolean}
+  none: {type: "null"}
+  tags:
+    type: array
+    items: {type: string}
+required: [name]
+additionalProperties: false
+`)
+
+	tests := []struct {
+		name    string
+		json    string
+		wantErr bool
+	}{
+		{"minimal", `{"name": "a"}`, false},
+		{"all", `{"name": "a", "count": 2, "score": 1.5, "done": true, "none": null, "tags": ["x", "y"]}`, false},
+		{"integer as float", `{"name": "a", "count": 2.0}`, false},
+		{"not an object", `["a"]`, true},
+		{"missing required", `{"count": 1}`, true},
+		{"additional property", `{"name": "a", "extra": 1}`, true},
+		{"wrong string", `{"name": 1}`, true},
+		{"fractional integer", `{"name": "a", "count": 1.5}`, true},
+		{"wrong number", `{"name": "a", "score": "1"}`, true},
+		{"wrong boolean", `{"name": "a", "done": "true"}`, true},
+		{"wrong null", `{"name": "a", "none": 0}`, true},
+		{"wrong array", `{"name": "a", "tags": "x"}`, true},
+		{"wrong item", `{"name": "a", "tags": ["x", 1]}`, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var v any
+			if err := json.Unmarshal([]byte(tt.json), &v); err != nil {
+				t.Fatal(err)
+			}
+
+			err := schema.Validate(v)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate(%s) error = %v, want error %v", tt.json, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSchemaValidateAdditionalProperties(t *testing.T) {
+	schema := mustSchema(t, "type: object\nproperties:\n  a: {type: string}")
+
+	var v any
+	if err := json.Unmarshal([]byte(`{"a": "x", "b": 1}`), &v); err != nil {
+		t.Fatal(err)
+	}
+	if err := schema.Validate(v); err != nil {
+		t.Errorf("Validate() error = %v, want additional properties allowed by default", err)
+	}
+}
+
+func TestSchemaEnum(t *testing.T) {
+	// The enum is decoded from YAML as int, float64, string, bool and nil, the values from JSON as float64 and
+	// the other JSON types, so they must be compared by their JSON representation.
+	schema := mustSchema(t, `enum: [1, 2.5, 3.0, "1", true, null, [1, a], {k: 1}]`)
+
+	tests := []struct {
+		json string
+		want bool
+	}{
+		{`1`, true},
+		{`1.0`, true},
+		{`2.5`, true},
+		{`3`, true},
+		{`"1"`, true},
+		{`true`, true},
+		{`null`, true},
+		{`[1, "a"]`, true},
+		{`{"k": 1.0}`, true},
+		{`2`, false},
+		{`"2.5"`, false},
+		{`false`, false},
+		{`"true"`, false},
+		{`[1]`, false},
+		{`{"k": "1"}`, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.json, func(t *testing.T) {
+			var v any
+			if err := json.Unmarshal([]byte(tt.json), &v); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := schema.Validate(v); (err == nil) != tt.want {
+				t.Errorf("Validate(%s) error = %v, want allowed %v", tt.json, err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package gpt
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// summaryChunkSize is the maximum size in bytes of the transcript sent in a single summary request,
+// leaving room for the prompt and the answer in the context window of common models.
+const summaryChunkSize = 16000
+
+// maxSummaryRounds is the maximum number of rounds in which partial summaries are summarized again.
+const maxSummaryRounds = 4
+
+// errSummaryTooLong is returned if the partial summaries don't fit into a single request after maxSummaryRounds.
+var errSummaryTooLong = errors.New("the transcript is too long to summarize")
+
+const (
+	summaryPrompt = "Summarize the following chat transcript for someone who missed it. " +
+		"Mention the main topics, decisions, open questions and who said what when relevant. " +
+		"Be concise, use a bulleted list and answer in the language of the conversation."
+	partialSummaryPrompt = "Summarize this part of a longer chat transcript. " +
+		"Keep the topics, decisions, open questions and names of the participants. Be concise."
+	combineSummaryPrompt = "Combine the following summaries of consecutive parts of a chat into a single summary " +
+		"for someone who missed it. Be concise, use a bulleted list and answer in the language of the summaries."
+)
+
+// Summarize summarizes the transcript lines. Transcripts exceeding the context window are split into chunks,
+// which are summarized separately and then combined.
+func (g *Gpt) Summarize(ctx context.Context, lines []string, opts CompletionOptions) (string, error) {
+	model := g.model
+	if opts.Model != "" {
+		model = opts.Model
+	}
+
+	chunks := chunkLines(lines, summaryChunkSize)
+	if len(chunks) == 1 {
+		return g.summarizeChunk(ctx, model, summaryPrompt, chunks[0], opts.Params)
+	}
+
+	for round := 0; len(chunks) > 1; round++ {
+		if round == maxSummaryRounds {
+			return "", errSummaryTooLong
+		}
+
+		summaries := make([]string, 0, len(chunks))
+		for _, chunk := range chunks {
+			s, err := g.summarizeChunk(ctx, model, partialSummaryPrompt, chunk, opts.Params)
+			if err != nil {
+				return "", err
+			}
+			summaries = append(summaries, s)
+		}
+		next := chunkLines(summaries, summaryChunkSize)
+		if len(next) >= len(chunks) {
+			// The summaries are as long as the parts, another round wouldn't get any shorter.
+			return "", errSummaryTooLong
+		}
+		chunks = next
+	}
+
+	return g.summarizeChunk(ctx, model, combineSummaryPrompt, chunks[0], opts.Params)
+}
+
+// summarizeChunk requests a summary of the text with the given instructions and parameters.
+func (g *Gpt) summarizeChunk(ctx context.Context, model, prompt, text string, params Params) (string, error) {
+	return g.complReqWithTimeout(ctx, model, []openai.ChatCompletionMessage{
+		{Role: openai.ChatMessageRoleSystem, Content: prompt},
+		{Role: openai.ChatMessageRoleUser, Content: text},
+	}, params)
+}
+
+// chunkLines joins the lines into chunks of at most size bytes. Longer lines are truncated at a rune boundary.
+func chunkLines(lines []string, size int) []string {
+	var chunks []string
+	var sb strings.Builder
+	for _, line := range lines {
+		if len(line) > size {
+			n := size
+			for n > 0 && !utf8.RuneStart(line[n]) {
+				n--
+			}
+			line = line[:n]
+		}
+		if sb.Len() > 0 && sb.Len()+len(line)+1 > size {
+			chunks = append(chunks, sb.String())
+			sb.Reset()
+		}
+		if sb.Len() > 0 {
+			sb.WriteByte('\n')
+		}
+		sb.WriteString(line)
+	}
+
+	return append(chunks, sb.String())
+}
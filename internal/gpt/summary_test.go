@@ -0,0 +1,58 @@
+package gpt
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestChunkLines(t *testing.T) {
+	tests := []struct {
+		name  string
+		lines []string
+		size  int
+		want  []string
+	}{
+		{"empty", nil, 10, []string{""}},
+		{"single chunk", []string{"ab", "cd"}, 10, []string{"ab\ncd"}},
+		{"exact fit", []string{"abcd", "efgh"}, 9, []string{"abcd\nefgh"}},
+		{"one byte over", []string{"abcd", "efghi"}, 9, []string{"abcd", "efghi"}},
+		{"several chunks", []string{"aaa", "bbb", "ccc", "ddd"}, 7, []string{"aaa\nbbb", "ccc\nddd"}},
+		{"long line truncated", []string{"abcdefghij", "k"}, 4, []string{"abcd", "k"}},
+		// "é" is two bytes, cutting at 4 bytes would split the second one.
+		{"truncated at rune boundary", []string{"aéé"}, 4, []string{"aé"}},
+		// The four byte emoji doesn't fit at all.
+		{"rune longer than the rest", []string{"ab😀"}, 5, []string{"ab"}},
+		{"rune exactly at the end", []string{"ab😀c"}, 6, []string{"ab😀"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := chunkLines(tt.lines, tt.size)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("chunkLines() = %q, want %q", got, tt.want)
+			}
+			for _, chunk := range got {
+				if len(chunk) > tt.size {
+					t.Errorf("chunk %q is longer than %d bytes", chunk, tt.size)
+				}
+				if !utf8.ValidString(chunk) {
+					t.Errorf("chunk %q is not valid UTF-8", chunk)
+				}
+			}
+		})
+	}
+}
+
+func TestChunkLinesKeepsAllLines(t *testing.T) {
+	lines := make([]string, 1000)
+	for i := range lines {
+		lines[i] = strings.Repeat("ä", i%50+1)
+	}
+
+	chunks := chunkLines(lines, 300)
+	if got := strings.Split(strings.Join(chunks, "\n"), "\n"); !reflect.DeepEqual(got, lines) {
+		t.Error("the chunks don't contain the lines in order")
+	}
+}
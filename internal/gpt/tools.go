@@ -0,0 +1,49 @@
+package gpt
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// maxToolRounds is the number of completion requests in which the model may call tools before it must answer.
+const maxToolRounds = 3
+
+const saveMemoryToolName = "save_memory"
+
+// saveMemoryTool lets the model remember a stable fact about the user across conversations.
+var saveMemoryTool = openai.Tool{
+	Type: openai.ToolTypeFunction,
+	Function: openai.FunctionDefinition{
+		Name: saveMemoryToolName,
+		Description: "Save a stable fact about the user or their preferences that is useful in future conversations, " +
+			"e.g. their job, team or preferred programming language. Only save facts the user states about themselves.",
+		Parameters: json.RawMessage(`{
+			"type": "object",
+			"properties": {
+				"fact": {"type": "string", "description": "The fact in a short third-person sentence."}
+			},
+			"required": ["fact"]
+		}`),
+	},
+}
+
+// callTool executes the tool call and returns the result for the model.
+func callTool(call openai.ToolCall, opts CompletionOptions) string {
+	if call.Function.Name != saveMemoryToolName || opts.SaveMemory == nil {
+		return "error: unknown tool"
+	}
+
+	var args struct {
+		Fact string `json:"fact"`
+	}
+	if err := json.Unmarshal([]byte(call.Function.Arguments), &args); err != nil || strings.TrimSpace(args.Fact) == "" {
+		return "error: invalid arguments"
+	}
+
+	if err := opts.SaveMemory(strings.TrimSpace(args.Fact)); err != nil {
+		return "error: " + err.Error()
+	}
+	return "saved"
+}
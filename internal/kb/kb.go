@@ -0,0 +1,172 @@
+package kb
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/mazzz1y/matrix-gpt/internal/store"
+)
+
+// chunkSize is the maximum size of a chunk in bytes, about 400 tokens of English text.
+const chunkSize = 1500
+
+// Embedder computes embedding vectors of texts.
+type Embedder interface {
+	CreateEmbeddings(ctx context.Context, texts []string) ([][]float32, error)
+}
+
+// KB is a knowledge base of documents split into chunks, searchable by semantic similarity.
+// The chunks are stored in the SQLite database, so documents ingested by another process are found as well.
+type KB struct {
+	store    *store.Store
+	embedder Embedder
+}
+
+// Result is a chunk found by Search.
+type Result struct {
+	Source  string
+	Index   int
+	Content string
+	Score   float32
+}
+
+// New creates a knowledge base on top of the store.
+func New(st *store.Store, embedder Embedder) *KB {
+	return &KB{store: st, embedder: embedder}
+}
+
+// Ingest splits the text into chunks, computes their embeddings and stores them under the source name,
+// replacing a previously ingested document with the same name. It returns the number of chunks.
+func (k *KB) Ingest(ctx context.Context, source, text string) (int, error) {
+	texts := Chunk(text, chunkSize)
+	if len(texts) == 0 {
+		return 0, errors.New("document is empty")
+	}
+
+	vectors, err := k.embedder.CreateEmbeddings(ctx, texts)
+	if err != nil {
+		return 0, err
+	}
+
+	chunks := make([]store.KBChunk, len(texts))
+	for i, t := range texts {
+		chunks[i] = store.KBChunk{Source: source, Index: i, Content: t, Embedding: vectors[i]}
+	}
+
+	return len(chunks), k.store.ReplaceKBSource(ctx, source, chunks)
+}
+
+// Search returns up to topK chunks most similar to the query with a score of at least minScore.
+// It returns nothing without requesting an embedding if the knowledge base is empty.
+func (k *KB) Search(ctx context.Context, query string, topK int, minScore float32) ([]Result, error) {
+	if topK <= 0 {
+		return nil, nil
+	}
+
+	ok, err := k.store.HasKBChunks(ctx)
+	if err != nil || !ok {
+		return nil, err
+	}
+
+	vectors, err := k.embedder.CreateEmbeddings(ctx, []string{query})
+	if err != nil {
+		return nil, err
+	}
+
+	chunks, err := k.store.GetKBChunks(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []Result
+	for _, c := range chunks {
+		score := cosine(vectors[0], c.Embedding)
+		if score >= minScore {
+			results = append(results, Result{Source: c.Source, Index: c.Index, Content: c.Content, Score: score})
+		}
+	}
+
+	sort.Slice(results, func(i, j int) bool { return results[i].Score > results[j].Score })
+	if len(results) > topK {
+		results = results[:topK]
+	}
+
+	return results, nil
+}
+
+// Sources returns the documents of the knowledge base.
+func (k *KB) Sources(ctx context.Context) ([]store.KBSource, error) {
+	return k.store.GetKBSources(ctx)
+}
+
+// Delete deletes the document, reporting whether it existed.
+func (k *KB) Delete(ctx context.Context, source string) (bool, error) {
+	return k.store.DeleteKBSource(ctx, source)
+}
+
+// Chunk splits the text into chunks of at most size bytes. Paragraphs are kept together where possible,
+// longer paragraphs are split between words.
+func Chunk(text string, size int) []string {
+	var chunks []string
+	var sb strings.Builder
+	flush := func() {
+		if s := strings.TrimSpace(sb.String()); s != "" {
+			chunks = append(chunks, s)
+		}
+		sb.Reset()
+	}
+
+	for _, para := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n\n") {
+		para = strings.TrimSpace(para)
+		if para == "" {
+			continue
+		}
+
+		if sb.Len() > 0 && sb.Len()+len(para)+2 > size {
+			flush()
+		}
+		if len(para) <= size {
+			if sb.Len() > 0 {
+				sb.WriteString("\n\n")
+			}
+			sb.WriteString(para)
+			continue
+		}
+
+		for _, word := range strings.Fields(para) {
+			if sb.Len() > 0 && sb.Len()+len(word)+1 > size {
+				flush()
+			}
+			if sb.Len() > 0 {
+				sb.WriteByte(' ')
+			}
+			sb.WriteString(word)
+		}
+		flush()
+	}
+	flush()
+
+	return chunks
+}
+
+// cosine returns the cosine similarity of two vectors, or 0 if their lengths differ.
+func cosine(a, b []float32) float32 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+
+	var dot, na, nb float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+
+	return float32(dot / (math.Sqrt(na) * math.Sqrt(nb)))
+}
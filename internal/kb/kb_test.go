@@ -0,0 +1,96 @@
+package kb
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestChunk(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		size int
+		want []string
+	}{
+		{"empty", "", 10, nil},
+		{"whitespace", " \n\n \n\n", 10, nil},
+		{"single paragraph", "hello world", 20, []string{"hello world"}},
+		{"paragraphs joined", "aaa\n\nbbb", 8, []string{"aaa\n\nbbb"}},
+		{"paragraphs split at the boundary", "aaa\n\nbbbb", 8, []string{"aaa", "bbbb"}},
+		{"windows line endings", "aaa\r\n\r\nbbb", 8, []string{"aaa\n\nbbb"}},
+		{"empty paragraphs skipped", "aaa\n\n\n\n\n\nbbb", 8, []string{"aaa\n\nbbb"}},
+		{"paragraph of exactly the size", "aaaa bbbb", 9, []string{"aaaa bbbb"}},
+		{"long paragraph split between words", "aaa bbb ccc ddd", 7, []string{"aaa bbb", "ccc ddd"}},
+		{"long paragraph after a short one", "x\n\naaa bbb ccc", 7, []string{"x", "aaa bbb", "ccc"}},
+		{"short paragraph after a long one", "aaa bbb ccc\n\nx", 7, []string{"aaa bbb", "ccc", "x"}},
+		{"word longer than the size", "a bbbbbbbbbb c", 4, []string{"a", "bbbbbbbbbb", "c"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Chunk(tt.text, tt.size); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Chunk(%q, %d) = %q, want %q", tt.text, tt.size, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChunkCoversText(t *testing.T) {
+	var words []string
+	var sb strings.Builder
+	for i := 0; i < 500; i++ {
+		word := strings.Repeat(string(rune('a'+i%26)), i%9+1)
+		words = append(words, word)
+		sb.WriteString(word)
+		if i%40 == 39 {
+			sb.WriteString("\n\n")
+		} else {
+			sb.WriteString(" ")
+		}
+	}
+
+	const size = 100
+	chunks := Chunk(sb.String(), size)
+
+	var got []string
+	for _, c := range chunks {
+		if len(c) > size {
+			t.Errorf("chunk of %d bytes exceeds %d: %q", len(c), size, c)
+		}
+		got = append(got, strings.Fields(c)...)
+	}
+
+	// Chunks don't overlap: every word is in exactly one chunk, in order.
+	if !reflect.DeepEqual(got, words) {
+		t.Error("the chunks don't contain every word exactly once and in order")
+	}
+}
+
+func TestCosine(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b []float32
+		want float32
+	}{
+		{"identical", []float32{1, 2, 3}, []float32{1, 2, 3}, 1},
+		{"scaled", []float32{1, 2, 3}, []float32{2, 4, 6}, 1},
+		{"opposite", []float32{1, 0}, []float32{-1, 0}, -1},
+		{"orthogonal", []float32{1, 0}, []float32{0, 1}, 0},
+		{"diagonal", []float32{1, 0}, []float32{1, 1}, float32(1 / math.Sqrt2)},
+		{"zero vector", []float32{0, 0}, []float32{1, 1}, 0},
+		{"both zero", []float32{0, 0}, []float32{0, 0}, 0},
+		{"different lengths", []float32{1, 2}, []float32{1, 2, 3}, 0},
+		{"empty", nil, nil, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := cosine(tt.a, tt.b)
+			if math.IsNaN(float64(got)) || math.Abs(float64(got-tt.want)) > 1e-6 {
+				t.Errorf("cosine(%v, %v) = %v, want %v", tt.a, tt.b, got, tt.want)
+			}
+		})
+	}
+}
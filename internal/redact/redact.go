@@ -0,0 +1,350 @@
+// Package redact replaces personal data and secrets in texts with placeholders that can be restored later.
+package redact
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Built-in detectors.
+const (
+	Email    = "email"
+	Phone    = "phone"
+	IBAN     = "iban"
+	APIKey   = "api_key"
+	Hostname = "hostname"
+)
+
+// Detectors lists the built-in detectors in the order they are applied.
+var Detectors = []string{Email, APIKey, IBAN, Phone, Hostname}
+
+// DefaultInternalDomains are the domain suffixes matched by the hostname detector if none are configured.
+var DefaultInternalDomains = []string{"internal", "local", "corp", "lan", "intranet", "home.arpa"}
+
+var (
+	emailRe = regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9-]+(?:\.[A-Za-z0-9-]+)*\.[A-Za-z]{2,}`)
+	// phoneRe matches international numbers and the common (123) 456-7890 and 123-456-7890 formats.
+	phoneRe = regexp.MustCompile(`\+\d{1,3}[ .-]?(?:\(\d{1,4}\)|\d{1,4})(?:[ .-]?\d{2,4}){1,4}|\(\d{3}\) ?\d{3}[ .-]\d{4}|\b\d{3}[.-]\d{3}[.-]\d{4}\b`)
+	ibanRe  = regexp.MustCompile(`\b[A-Z]{2}\d{2}(?: ?[A-Z0-9]{4}){2,7}(?: ?[A-Z0-9]{1,4})?\b`)
+	// apiKeyRe matches the key formats of common providers and JSON web tokens.
+	apiKeyRe = regexp.MustCompile(`\b(?:sk-[A-Za-z0-9_-]{20,}|gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{22,}|` +
+		`glpat-[A-Za-z0-9_-]{20,}|xox[abprs]-[A-Za-z0-9-]{10,}|AKIA[0-9A-Z]{16}|AIza[0-9A-Za-z_-]{35}|` +
+		`eyJ[A-Za-z0-9_-]{10,}\.[A-Za-z0-9_-]{10,}\.[A-Za-z0-9_-]{10,})`)
+)
+
+// Config configures a Redactor.
+type Config struct {
+	// Detectors are the enabled built-in detectors. All are enabled if empty.
+	Detectors []string
+	// InternalDomains are the domain suffixes matched by the hostname detector, DefaultInternalDomains if empty.
+	InternalDomains []string
+	// Patterns are custom rules in the form `NAME=regexp`. Matches are replaced with `[NAME_n]` placeholders.
+	Patterns []string
+	// Keywords are replaced case-insensitively as whole words with `[KEYWORD_n]` placeholders.
+	Keywords []string
+}
+
+// rule replaces the matches of a regexp with placeholders of a kind.
+type rule struct {
+	kind  string
+	re    *regexp.Regexp
+	valid func(string) bool
+}
+
+// Redactor replaces the matches of its rules with placeholders.
+type Redactor struct {
+	rules []rule
+}
+
+// New builds a Redactor from the configuration.
+func New(cfg Config) (*Redactor, error) {
+	detectors := cfg.Detectors
+	if len(detectors) == 0 {
+		detectors = Detectors
+	}
+	enabled := make(map[string]bool, len(detectors))
+	for _, d := range detectors {
+		if !isDetector(d) {
+			return nil, fmt.Errorf("unknown detector %q, detectors: %s", d, strings.Join(Detectors, ", "))
+		}
+		enabled[d] = true
+	}
+
+	r := &Redactor{}
+	for _, d := range Detectors {
+		if !enabled[d] {
+			continue
+		}
+		switch d {
+		case Email:
+			r.rules = append(r.rules, rule{kind: "EMAIL", re: emailRe})
+		case APIKey:
+			r.rules = append(r.rules, rule{kind: "API_KEY", re: apiKeyRe})
+		case IBAN:
+			r.rules = append(r.rules, rule{kind: "IBAN", re: ibanRe, valid: validIBAN})
+		case Phone:
+			r.rules = append(r.rules, rule{kind: "PHONE", re: phoneRe, valid: validPhone})
+		case Hostname:
+			re, err := hostnameRegexp(cfg.InternalDomains)
+			if err != nil {
+				return nil, err
+			}
+			r.rules = append(r.rules, rule{kind: "HOST", re: re})
+		}
+	}
+
+	for _, p := range cfg.Patterns {
+		name, expr, ok := strings.Cut(p, "=")
+		if !ok || !validKind(name) {
+			return nil, fmt.Errorf("invalid pattern %q, expected NAME=regexp", p)
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", p, err)
+		}
+		r.rules = append(r.rules, rule{kind: strings.ToUpper(name), re: re})
+	}
+
+	if len(cfg.Keywords) > 0 {
+		quoted := make([]string, len(cfg.Keywords))
+		for i, k := range cfg.Keywords {
+			quoted[i] = regexp.QuoteMeta(k)
+		}
+		re := regexp.MustCompile(`(?i)\b(?:` + strings.Join(quoted, "|") + `)\b`)
+		r.rules = append(r.rules, rule{kind: "KEYWORD", re: re})
+	}
+
+	return r, nil
+}
+
+// Redact replaces the matches of the rules in the text with placeholders recorded in the mapping.
+// The same value is always replaced with the same placeholder of the mapping. A nil Redactor returns the text as is.
+func (r *Redactor) Redact(text string, m *Mapping) string {
+	if r == nil {
+		return text
+	}
+	for _, rl := range r.rules {
+		text = rl.re.ReplaceAllStringFunc(text, func(match string) string {
+			value := rl.validPrefix(match)
+			if value == "" {
+				return match
+			}
+			return m.placeholder(rl.kind, value) + match[len(value):]
+		})
+	}
+	return text
+}
+
+// validPrefix returns the longest valid prefix of the match that ends before a space, or an empty string.
+// Shorter prefixes are tried because the regexp may include a following word, e.g. the `EUR` in `BE68 … 7034 EUR`.
+func (rl rule) validPrefix(match string) string {
+	if rl.valid == nil {
+		return match
+	}
+
+	for value := match; ; {
+		if rl.valid(value) {
+			return value
+		}
+		i := strings.LastIndexByte(value, ' ')
+		if i < 0 {
+			return ""
+		}
+		value = value[:i]
+	}
+}
+
+// Mapping holds the placeholders of redacted values so they can be restored. It is safe for concurrent use.
+// Placeholders carry a random tag of the mapping generation, e.g. `[EMAIL_1_3fa9c2]`, so placeholders of an earlier
+// generation, e.g. in a conversation saved before a restart, are never restored to the values of a later one.
+type Mapping struct {
+	mu       sync.Mutex
+	tag      string
+	byValue  map[string]string
+	byHolder map[string]string
+	counts   map[string]int
+	restorer *strings.Replacer
+}
+
+// NewMapping returns an empty mapping with a new generation tag.
+func NewMapping() *Mapping {
+	return newMapping(newTag())
+}
+
+// newMapping returns an empty mapping with the tag, placeholders have no tag if it is empty.
+func newMapping(tag string) *Mapping {
+	m := &Mapping{}
+	m.reset(tag)
+	return m
+}
+
+// Reset forgets all placeholders and starts a new generation.
+func (m *Mapping) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.reset(newTag())
+}
+
+func (m *Mapping) reset(tag string) {
+	m.tag = tag
+	m.byValue = make(map[string]string)
+	m.byHolder = make(map[string]string)
+	m.counts = make(map[string]int)
+	m.restorer = nil
+}
+
+// placeholder returns the placeholder of the value, creating a new one of the kind if needed.
+func (m *Mapping) placeholder(kind, value string) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := kind + "\x00" + value
+	if p, ok := m.byValue[key]; ok {
+		return p
+	}
+
+	m.counts[kind]++
+	p := fmt.Sprintf("[%s_%d]", kind, m.counts[kind])
+	if m.tag != "" {
+		p = fmt.Sprintf("[%s_%d_%s]", kind, m.counts[kind], m.tag)
+	}
+	m.byValue[key] = p
+	m.byHolder[p] = value
+	m.restorer = nil
+
+	return p
+}
+
+// Restore replaces the placeholders in the text with the original values.
+func (m *Mapping) Restore(text string) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(m.byHolder) == 0 {
+		return text
+	}
+	if m.restorer == nil {
+		pairs := make([]string, 0, len(m.byHolder)*2)
+		for p, v := range m.byHolder {
+			pairs = append(pairs, p, v)
+		}
+		m.restorer = strings.NewReplacer(pairs...)
+	}
+
+	return m.restorer.Replace(text)
+}
+
+// RestoreJSON replaces the placeholders in the JSON text with the original values escaped for JSON strings.
+// Placeholders contain no characters that need escaping, so they are only found inside strings.
+func (m *Mapping) RestoreJSON(text string) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(m.byHolder) == 0 {
+		return text
+	}
+	pairs := make([]string, 0, len(m.byHolder)*2)
+	for p, v := range m.byHolder {
+		var buf bytes.Buffer
+		enc := json.NewEncoder(&buf)
+		enc.SetEscapeHTML(false)
+		if err := enc.Encode(v); err != nil {
+			continue
+		}
+		// Strip the quotes and the newline added by the encoder.
+		escaped := strings.TrimSuffix(buf.String(), "\n")
+		pairs = append(pairs, p, escaped[1:len(escaped)-1])
+	}
+
+	return strings.NewReplacer(pairs...).Replace(text)
+}
+
+// newTag returns a random generation tag of six hex digits.
+func newTag() string {
+	b := make([]byte, 3)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// hostnameRegexp matches host names ending with one of the domains.
+func hostnameRegexp(domains []string) (*regexp.Regexp, error) {
+	if len(domains) == 0 {
+		domains = DefaultInternalDomains
+	}
+
+	quoted := make([]string, len(domains))
+	for i, d := range domains {
+		d = strings.Trim(strings.TrimSpace(d), ".")
+		if d == "" {
+			return nil, fmt.Errorf("invalid internal domain %q", domains[i])
+		}
+		quoted[i] = regexp.QuoteMeta(d)
+	}
+
+	return regexp.Compile(`(?i)\b(?:[a-z0-9](?:[a-z0-9-]{0,61}[a-z0-9])?\.)+(?:` + strings.Join(quoted, "|") + `)\b`)
+}
+
+// validIBAN checks the length and the mod-97 checksum of the IBAN.
+func validIBAN(s string) bool {
+	s = strings.ReplaceAll(s, " ", "")
+	if len(s) < 15 || len(s) > 34 {
+		return false
+	}
+
+	// Move the country code and the check digits to the end and convert the letters to numbers.
+	var digits strings.Builder
+	for _, c := range s[4:] + s[:4] {
+		if c >= 'A' && c <= 'Z' {
+			fmt.Fprintf(&digits, "%d", c-'A'+10)
+		} else {
+			digits.WriteRune(c)
+		}
+	}
+
+	n, ok := new(big.Int).SetString(digits.String(), 10)
+	return ok && new(big.Int).Mod(n, big.NewInt(97)).Int64() == 1
+}
+
+// validPhone checks that the number has between 7 and 15 digits.
+func validPhone(s string) bool {
+	var n int
+	for _, c := range s {
+		if c >= '0' && c <= '9' {
+			n++
+		}
+	}
+	return n >= 7 && n <= 15
+}
+
+// isDetector reports whether the name is a built-in detector.
+func isDetector(name string) bool {
+	for _, d := range Detectors {
+		if d == name {
+			return true
+		}
+	}
+	return false
+}
+
+// validKind reports whether the name can be used in placeholders.
+func validKind(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		if !(c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,199 @@
+package schedule
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// minInterval is the shortest allowed interval of recurring schedules.
+const minInterval = time.Minute
+
+var errInvalidSpec = errors.New("invalid schedule, use e.g. `in 2h`, `at 09:00`, `every 30m`, `every day 09:00` or `every weekday 09:00`")
+
+// Schedule describes when a job runs.
+//
+// Supported specs:
+//   - in <duration>: once after the duration, e.g. "in 2h", "in 1d12h"
+//   - at <HH:MM>: once at the next occurrence of the time
+//   - every <duration>: repeatedly with the interval, e.g. "every 30m"
+//   - every <day|weekday|monday..sunday> <HH:MM>: repeatedly at the time on matching days
+type Schedule struct {
+	spec     string
+	once     time.Time
+	interval time.Duration
+	days     []time.Weekday
+	clock    time.Duration
+}
+
+// Parse parses the schedule spec relative to now. The time of day is interpreted in the location of now.
+func Parse(spec string, now time.Time) (*Schedule, error) {
+	fields := strings.Fields(strings.ToLower(spec))
+	if len(fields) < 2 {
+		return nil, errInvalidSpec
+	}
+
+	s := &Schedule{spec: strings.Join(fields, " ")}
+	switch {
+	case fields[0] == "in" && len(fields) == 2:
+		d, err := parseDuration(fields[1])
+		if err != nil || d <= 0 {
+			return nil, errInvalidSpec
+		}
+		s.once = now.Add(d)
+	case fields[0] == "at" && len(fields) == 2:
+		clock, err := parseClock(fields[1])
+		if err != nil {
+			return nil, err
+		}
+		s.clock = clock
+		s.once = s.nextClock(now, allDays)
+	case fields[0] == "every" && len(fields) == 2:
+		d, err := parseDuration(fields[1])
+		if err != nil {
+			return nil, errInvalidSpec
+		}
+		if d < minInterval {
+			return nil, fmt.Errorf("the interval must be at least %s", minInterval)
+		}
+		s.interval = d
+	case fields[0] == "every" && len(fields) == 3:
+		days, ok := dayNames[fields[1]]
+		if !ok {
+			return nil, errInvalidSpec
+		}
+		clock, err := parseClock(fields[2])
+		if err != nil {
+			return nil, err
+		}
+		s.days = days
+		s.clock = clock
+	default:
+		return nil, errInvalidSpec
+	}
+
+	return s, nil
+}
+
+// Split splits a message into the leading schedule spec and the remaining text.
+// The spec is either quoted or the longest sequence of leading words forming a valid spec.
+func Split(msg string, now time.Time) (*Schedule, string, error) {
+	msg = strings.TrimSpace(msg)
+	if strings.HasPrefix(msg, `"`) {
+		spec, rest, ok := strings.Cut(msg[1:], `"`)
+		if !ok {
+			return nil, "", errInvalidSpec
+		}
+		s, err := Parse(spec, now)
+		return s, strings.TrimSpace(rest), err
+	}
+
+	fields := strings.Fields(msg)
+	n := len(fields)
+	if n > 3 {
+		n = 3
+	}
+	for ; n >= 2; n-- {
+		s, err := Parse(strings.Join(fields[:n], " "), now)
+		if err == nil {
+			return s, strings.Join(fields[n:], " "), nil
+		}
+	}
+
+	return nil, "", errInvalidSpec
+}
+
+// Recurring reports whether the schedule runs more than once.
+func (s *Schedule) Recurring() bool {
+	return s.once.IsZero()
+}
+
+// Next returns the first run time after t, or the zero time if a one-time schedule has already run.
+func (s *Schedule) Next(t time.Time) time.Time {
+	switch {
+	case !s.once.IsZero():
+		if s.once.After(t) {
+			return s.once
+		}
+		return time.Time{}
+	case s.interval > 0:
+		return t.Add(s.interval)
+	default:
+		return s.nextClock(t, s.days)
+	}
+}
+
+// String returns the normalized spec.
+func (s *Schedule) String() string {
+	return s.spec
+}
+
+// nextClock returns the first time of day after t on one of the days.
+func (s *Schedule) nextClock(t time.Time, days []time.Weekday) time.Time {
+	hour, minute := int(s.clock/time.Hour), int(s.clock%time.Hour/time.Minute)
+	for i := 0; i <= 7; i++ {
+		next := time.Date(t.Year(), t.Month(), t.Day()+i, hour, minute, 0, 0, t.Location())
+		if next.After(t) && containsDay(days, next.Weekday()) {
+			return next
+		}
+	}
+	return time.Time{}
+}
+
+var (
+	allDays  = []time.Weekday{time.Sunday, time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday, time.Saturday}
+	weekdays = []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday}
+)
+
+// dayNames maps the day part of a spec to the matching weekdays.
+var dayNames = map[string][]time.Weekday{
+	"day":       allDays,
+	"weekday":   weekdays,
+	"sunday":    {time.Sunday},
+	"monday":    {time.Monday},
+	"tuesday":   {time.Tuesday},
+	"wednesday": {time.Wednesday},
+	"thursday":  {time.Thursday},
+	"friday":    {time.Friday},
+	"saturday":  {time.Saturday},
+}
+
+// parseDuration parses a Go duration, additionally accepting days, e.g. "1d12h".
+func parseDuration(s string) (time.Duration, error) {
+	var days time.Duration
+	if before, after, ok := strings.Cut(s, "d"); ok {
+		n, err := strconv.Atoi(before)
+		if err != nil {
+			return 0, err
+		}
+		days = time.Duration(n) * 24 * time.Hour
+		if after == "" {
+			return days, nil
+		}
+		s = after
+	}
+
+	d, err := time.ParseDuration(s)
+	return days + d, err
+}
+
+// parseClock parses a time of day in the HH:MM format.
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, use the HH:MM format", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// containsDay reports whether the day is in the list.
+func containsDay(days []time.Weekday, day time.Weekday) bool {
+	for _, d := range days {
+		if d == day {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,176 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+// now is a Wednesday.
+var now = time.Date(2024, 5, 8, 10, 30, 0, 0, time.UTC)
+
+func date(day, hour, minute int) time.Time {
+	return time.Date(2024, 5, day, hour, minute, 0, 0, time.UTC)
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		spec      string
+		wantSpec  string
+		recurring bool
+		next      time.Time
+		wantErr   bool
+	}{
+		{spec: "in 2h", wantSpec: "in 2h", next: date(8, 12, 30)},
+		{spec: "in 1d12h", wantSpec: "in 1d12h", next: date(9, 22, 30)},
+		{spec: "in 2d", wantSpec: "in 2d", next: date(10, 10, 30)},
+		{spec: "  IN   90m ", wantSpec: "in 90m", next: date(8, 12, 0)},
+		{spec: "at 11:00", wantSpec: "at 11:00", next: date(8, 11, 0)},
+		{spec: "at 09:00", wantSpec: "at 09:00", next: date(9, 9, 0)},
+		{spec: "at 10:30", wantSpec: "at 10:30", next: date(9, 10, 30)},
+		{spec: "every 30m", wantSpec: "every 30m", recurring: true, next: date(8, 11, 0)},
+		{spec: "every 1d", wantSpec: "every 1d", recurring: true, next: date(9, 10, 30)},
+		{spec: "every day 09:00", wantSpec: "every day 09:00", recurring: true, next: date(9, 9, 0)},
+		{spec: "every day 23:59", wantSpec: "every day 23:59", recurring: true, next: date(8, 23, 59)},
+		{spec: "every weekday 09:00", wantSpec: "every weekday 09:00", recurring: true, next: date(9, 9, 0)},
+		{spec: "every wednesday 10:30", wantSpec: "every wednesday 10:30", recurring: true, next: date(15, 10, 30)},
+		{spec: "every Monday 08:00", wantSpec: "every monday 08:00", recurring: true, next: date(13, 8, 0)},
+		{spec: "in", wantErr: true},
+		{spec: "in 0s", wantErr: true},
+		{spec: "in -1h", wantErr: true},
+		{spec: "in soon", wantErr: true},
+		{spec: "at 25:00", wantErr: true},
+		{spec: "at 9am", wantErr: true},
+		{spec: "every 30s", wantErr: true},
+		{spec: "every month 09:00", wantErr: true},
+		{spec: "every day 9:00pm", wantErr: true},
+		{spec: "tomorrow 09:00", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			s, err := Parse(tt.spec, now)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Parse(%q) = %q, want an error", tt.spec, s)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if s.String() != tt.wantSpec {
+				t.Errorf("String() = %q, want %q", s.String(), tt.wantSpec)
+			}
+			if s.Recurring() != tt.recurring {
+				t.Errorf("Recurring() = %v, want %v", s.Recurring(), tt.recurring)
+			}
+			if next := s.Next(now); !next.Equal(tt.next) {
+				t.Errorf("Next() = %s, want %s", next, tt.next)
+			}
+		})
+	}
+}
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		msg      string
+		wantSpec string
+		wantText string
+		wantErr  bool
+	}{
+		{msg: "in 2h check the deploy", wantSpec: "in 2h", wantText: "check the deploy"},
+		{msg: "at 09:00 standup", wantSpec: "at 09:00", wantText: "standup"},
+		{msg: "every 30m drink water", wantSpec: "every 30m", wantText: "drink water"},
+		// The longest valid prefix wins, "every day" alone is not a spec.
+		{msg: "every day 09:00 summarize the news", wantSpec: "every day 09:00", wantText: "summarize the news"},
+		{msg: "every weekday 09:00 !summarize since 24h", wantSpec: "every weekday 09:00", wantText: "!summarize since 24h"},
+		// "every 1h" is valid, but "every 1h 30m" is not, so the rest is text.
+		{msg: "every 1h 30m break", wantSpec: "every 1h", wantText: "30m break"},
+		{msg: `"every weekday 09:00" summarize yesterday's discussion`, wantSpec: "every weekday 09:00", wantText: "summarize yesterday's discussion"},
+		{msg: `  "in 1d"   call mom  `, wantSpec: "in 1d", wantText: "call mom"},
+		{msg: "in 2h", wantSpec: "in 2h", wantText: ""},
+		{msg: `"every day" 09:00 text`, wantErr: true},
+		{msg: `"in 2h text`, wantErr: true},
+		{msg: "tomorrow call mom", wantErr: true},
+		{msg: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			s, text, err := Split(tt.msg, now)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Split(%q) = %q, %q, want an error", tt.msg, s, text)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if s.String() != tt.wantSpec || text != tt.wantText {
+				t.Errorf("Split(%q) = %q, %q, want %q, %q", tt.msg, s, text, tt.wantSpec, tt.wantText)
+			}
+		})
+	}
+}
+
+func TestNext(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	local := func(month time.Month, day, hour, minute int) time.Time {
+		return time.Date(2024, month, day, hour, minute, 0, 0, berlin)
+	}
+
+	tests := []struct {
+		name string
+		spec string
+		from time.Time
+		want time.Time
+	}{
+		{"weekday on friday evening", "every weekday 09:00", local(5, 10, 18, 0), local(5, 13, 9, 0)},
+		{"weekday on saturday", "every weekday 09:00", local(5, 11, 8, 0), local(5, 13, 9, 0)},
+		{"weekday before the time", "every weekday 09:00", local(5, 13, 8, 59), local(5, 13, 9, 0)},
+		{"weekday at the time", "every weekday 09:00", local(5, 13, 9, 0), local(5, 14, 9, 0)},
+		{"same weekday after the time", "every monday 09:00", local(5, 13, 9, 1), local(5, 20, 9, 0)},
+		{"sunday across the week", "every sunday 20:00", local(5, 13, 9, 0), local(5, 19, 20, 0)},
+		{"month boundary", "every day 09:00", local(5, 31, 10, 0), local(6, 1, 9, 0)},
+		// The clock time is kept across DST changes, the day is 23 or 25 hours long.
+		{"spring forward", "every day 09:00", local(3, 30, 9, 0), local(3, 31, 9, 0)},
+		{"fall back", "every day 09:00", local(10, 26, 9, 0), local(10, 27, 9, 0)},
+		// Intervals are exact durations, they move the clock time across DST changes.
+		{"interval spring forward", "every 1d", local(3, 30, 9, 0), local(3, 31, 10, 0)},
+		{"interval fall back", "every 1d", local(10, 26, 9, 0), local(10, 27, 8, 0)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := Parse(tt.spec, tt.from)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := s.Next(tt.from); !got.Equal(tt.want) {
+				t.Errorf("Next(%s) = %s, want %s", tt.from, got.In(berlin), tt.want)
+			}
+		})
+	}
+}
+
+func TestNextOnce(t *testing.T) {
+	s, err := Parse("in 1h", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := s.Next(now), now.Add(time.Hour); !got.Equal(want) {
+		t.Errorf("Next() before the run = %s, want %s", got, want)
+	}
+	if got := s.Next(now.Add(time.Hour)); !got.IsZero() {
+		t.Errorf("Next() after the run = %s, want the zero time", got)
+	}
+}
@@ -0,0 +1,75 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Conversation is a named snapshot of the history of a user.
+type Conversation struct {
+	Name      string
+	Messages  []byte
+	UpdatedAt time.Time
+}
+
+// SaveConversation stores the serialized messages under the name, replacing a conversation with the same name.
+func (s *Store) SaveConversation(ctx context.Context, userID, name string, messages []byte) error {
+	return s.exec(ctx,
+		"INSERT OR REPLACE INTO conversation (user_id, name, messages, updated_at) VALUES ($1, $2, $3, $4)",
+		userID, name, messages, time.Now().Unix(),
+	)
+}
+
+// GetConversation returns the conversation of the user with the name, or nil if it doesn't exist.
+func (s *Store) GetConversation(ctx context.Context, userID, name string) (*Conversation, error) {
+	c := Conversation{Name: name}
+	var updatedAt int64
+	err := s.db.QueryRowContext(ctx,
+		"SELECT messages, updated_at FROM conversation WHERE user_id = $1 AND name = $2", userID, name,
+	).Scan(&c.Messages, &updatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c.UpdatedAt = time.Unix(updatedAt, 0)
+	return &c, nil
+}
+
+// GetConversations returns the conversations of the user without their messages, most recently updated first.
+func (s *Store) GetConversations(ctx context.Context, userID string) ([]Conversation, error) {
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT name, updated_at FROM conversation WHERE user_id = $1 ORDER BY updated_at DESC", userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var conversations []Conversation
+	for rows.Next() {
+		var c Conversation
+		var updatedAt int64
+		if err := rows.Scan(&c.Name, &updatedAt); err != nil {
+			return nil, err
+		}
+		c.UpdatedAt = time.Unix(updatedAt, 0)
+		conversations = append(conversations, c)
+	}
+
+	return conversations, rows.Err()
+}
+
+// DeleteConversation deletes the conversation of the user, reporting whether it existed.
+func (s *Store) DeleteConversation(ctx context.Context, userID, name string) (bool, error) {
+	res, err := s.db.ExecContext(ctx, "DELETE FROM conversation WHERE user_id = $1 AND name = $2", userID, name)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
@@ -0,0 +1,103 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Answer is an answer sent to a user, recorded so that it can be rated later.
+type Answer struct {
+	EventID   string
+	UserID    string
+	RoomID    string
+	Prompt    string
+	Answer    string
+	Model     string
+	Persona   string
+	CreatedAt time.Time
+}
+
+// Feedback is the rating of an answer by a user.
+type Feedback struct {
+	UserID    string
+	RoomID    string
+	EventID   string
+	Rating    int
+	Prompt    string
+	Answer    string
+	Model     string
+	Persona   string
+	CreatedAt time.Time
+}
+
+// PutFeedback stores the feedback, replacing a previous rating of the same answer by the user.
+func (s *Store) PutFeedback(ctx context.Context, f Feedback) error {
+	return s.exec(ctx,
+		`INSERT OR REPLACE INTO feedback (user_id, room_id, event_id, rating, prompt, answer, model, persona, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		f.UserID, f.RoomID, f.EventID, f.Rating, f.Prompt, f.Answer, f.Model, f.Persona, f.CreatedAt.Unix(),
+	)
+}
+
+// PutAnswer stores the answer, replacing a previous answer with the same event, e.g. after regenerating it.
+func (s *Store) PutAnswer(ctx context.Context, a Answer) error {
+	return s.exec(ctx,
+		`INSERT OR REPLACE INTO answer (event_id, user_id, room_id, prompt, answer, model, persona, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		a.EventID, a.UserID, a.RoomID, a.Prompt, a.Answer, a.Model, a.Persona, a.CreatedAt.Unix(),
+	)
+}
+
+// GetAnswer returns the answer sent with the event, or nil if it doesn't exist.
+func (s *Store) GetAnswer(ctx context.Context, eventID string) (*Answer, error) {
+	a := Answer{EventID: eventID}
+	var createdAt int64
+	err := s.db.QueryRowContext(ctx,
+		"SELECT user_id, room_id, prompt, answer, model, persona, created_at FROM answer WHERE event_id = $1", eventID,
+	).Scan(&a.UserID, &a.RoomID, &a.Prompt, &a.Answer, &a.Model, &a.Persona, &createdAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	a.CreatedAt = time.Unix(createdAt, 0)
+	return &a, nil
+}
+
+// DeleteAnswersBefore deletes the answers sent before t, returning the number of deleted answers.
+// Ratings of the deleted answers are kept.
+func (s *Store) DeleteAnswersBefore(ctx context.Context, t time.Time) (int64, error) {
+	res, err := s.db.ExecContext(ctx, "DELETE FROM answer WHERE created_at < $1", t.Unix())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// GetFeedback returns all feedback, oldest first.
+func (s *Store) GetFeedback(ctx context.Context) ([]Feedback, error) {
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT user_id, room_id, event_id, rating, prompt, answer, model, persona, created_at FROM feedback ORDER BY created_at",
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var feedback []Feedback
+	for rows.Next() {
+		var f Feedback
+		var createdAt int64
+		if err := rows.Scan(&f.UserID, &f.RoomID, &f.EventID, &f.Rating, &f.Prompt, &f.Answer, &f.Model, &f.Persona, &createdAt); err != nil {
+			return nil, err
+		}
+		f.CreatedAt = time.Unix(createdAt, 0)
+		feedback = append(feedback, f)
+	}
+
+	return feedback, rows.Err()
+}
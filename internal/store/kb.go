@@ -0,0 +1,119 @@
+package store
+
+import (
+	"context"
+	"encoding/binary"
+	"math"
+)
+
+// KBChunk is a chunk of a knowledge base document with its embedding.
+type KBChunk struct {
+	Source    string
+	Index     int
+	Content   string
+	Embedding []float32
+}
+
+// KBSource is a document of the knowledge base.
+type KBSource struct {
+	Name   string
+	Chunks int
+}
+
+// ReplaceKBSource replaces all chunks of the source with the given ones.
+func (s *Store) ReplaceKBSource(ctx context.Context, source string, chunks []KBChunk) error {
+	return s.db.DoTxn(ctx, nil, func(ctx context.Context) error {
+		conn := s.db.Conn(ctx)
+		if _, err := conn.ExecContext(ctx, "DELETE FROM kb_chunk WHERE source = $1", source); err != nil {
+			return err
+		}
+
+		for _, c := range chunks {
+			_, err := conn.ExecContext(ctx,
+				"INSERT INTO kb_chunk (source, chunk, content, embedding) VALUES ($1, $2, $3, $4)",
+				source, c.Index, c.Content, encodeEmbedding(c.Embedding),
+			)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// GetKBChunks returns all chunks of the knowledge base.
+func (s *Store) GetKBChunks(ctx context.Context) ([]KBChunk, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT source, chunk, content, embedding FROM kb_chunk")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var chunks []KBChunk
+	for rows.Next() {
+		var c KBChunk
+		var embedding []byte
+		if err := rows.Scan(&c.Source, &c.Index, &c.Content, &embedding); err != nil {
+			return nil, err
+		}
+		c.Embedding = decodeEmbedding(embedding)
+		chunks = append(chunks, c)
+	}
+
+	return chunks, rows.Err()
+}
+
+// GetKBSources returns the documents of the knowledge base, ordered by name.
+func (s *Store) GetKBSources(ctx context.Context) ([]KBSource, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT source, COUNT(*) FROM kb_chunk GROUP BY source ORDER BY source")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sources []KBSource
+	for rows.Next() {
+		var src KBSource
+		if err := rows.Scan(&src.Name, &src.Chunks); err != nil {
+			return nil, err
+		}
+		sources = append(sources, src)
+	}
+
+	return sources, rows.Err()
+}
+
+// HasKBChunks reports whether the knowledge base is not empty.
+func (s *Store) HasKBChunks(ctx context.Context) (bool, error) {
+	var exists bool
+	err := s.db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM kb_chunk)").Scan(&exists)
+	return exists, err
+}
+
+// DeleteKBSource deletes all chunks of the source, reporting whether it existed.
+func (s *Store) DeleteKBSource(ctx context.Context, source string) (bool, error) {
+	res, err := s.db.ExecContext(ctx, "DELETE FROM kb_chunk WHERE source = $1", source)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// encodeEmbedding encodes the vector as little-endian float32 values.
+func encodeEmbedding(v []float32) []byte {
+	buf := make([]byte, 4*len(v))
+	for i, f := range v {
+		binary.LittleEndian.PutUint32(buf[4*i:], math.Float32bits(f))
+	}
+	return buf
+}
+
+// decodeEmbedding decodes a vector encoded by encodeEmbedding.
+func decodeEmbedding(buf []byte) []float32 {
+	v := make([]float32, len(buf)/4)
+	for i := range v {
+		v[i] = math.Float32frombits(binary.LittleEndian.Uint32(buf[4*i:]))
+	}
+	return v
+}
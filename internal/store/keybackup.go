@@ -0,0 +1,47 @@
+package store
+
+import (
+	"context"
+)
+
+// GroupSession identifies an inbound Megolm session in the crypto store.
+type GroupSession struct {
+	RoomID    string
+	SenderKey string
+	SessionID string
+}
+
+// GetSessionsNotBackedUp returns inbound Megolm sessions of the crypto store that are not uploaded to the backup version yet.
+func (s *Store) GetSessionsNotBackedUp(ctx context.Context, version string, limit int) ([]GroupSession, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT s.room_id, s.sender_key, s.session_id FROM crypto_megolm_inbound_session s
+		LEFT JOIN key_backup k ON k.session_id = s.session_id AND k.version = $1
+		WHERE s.session IS NOT NULL AND k.session_id IS NULL
+		LIMIT $2`, version, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []GroupSession
+	for rows.Next() {
+		var gs GroupSession
+		if err := rows.Scan(&gs.RoomID, &gs.SenderKey, &gs.SessionID); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, gs)
+	}
+
+	return sessions, rows.Err()
+}
+
+// MarkSessionsBackedUp records that the sessions are stored in the backup version.
+func (s *Store) MarkSessionsBackedUp(ctx context.Context, version string, sessionIDs []string) error {
+	for _, id := range sessionIDs {
+		err := s.exec(ctx, "INSERT OR IGNORE INTO key_backup (version, session_id) VALUES ($1, $2)", version, id)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
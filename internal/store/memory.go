@@ -0,0 +1,66 @@
+package store
+
+import (
+	"context"
+	"time"
+)
+
+// Memory is a fact the bot remembers about a user.
+type Memory struct {
+	ID        int64
+	Fact      string
+	CreatedAt time.Time
+}
+
+// AddMemory stores a fact about the user and returns its ID.
+func (s *Store) AddMemory(ctx context.Context, userID, fact string) (int64, error) {
+	res, err := s.db.ExecContext(ctx,
+		"INSERT INTO memory (user_id, fact, created_at) VALUES ($1, $2, $3)",
+		userID, fact, time.Now().Unix(),
+	)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// GetMemories returns the facts about the user, oldest first.
+func (s *Store) GetMemories(ctx context.Context, userID string) ([]Memory, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT id, fact, created_at FROM memory WHERE user_id = $1 ORDER BY id", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var memories []Memory
+	for rows.Next() {
+		var m Memory
+		var createdAt int64
+		if err := rows.Scan(&m.ID, &m.Fact, &createdAt); err != nil {
+			return nil, err
+		}
+		m.CreatedAt = time.Unix(createdAt, 0)
+		memories = append(memories, m)
+	}
+
+	return memories, rows.Err()
+}
+
+// DeleteMemory deletes a fact about the user, reporting whether it existed.
+func (s *Store) DeleteMemory(ctx context.Context, id int64, userID string) (bool, error) {
+	res, err := s.db.ExecContext(ctx, "DELETE FROM memory WHERE id = $1 AND user_id = $2", id, userID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// DeleteMemories deletes all facts about the user and returns their number.
+func (s *Store) DeleteMemories(ctx context.Context, userID string) (int64, error) {
+	res, err := s.db.ExecContext(ctx, "DELETE FROM memory WHERE user_id = $1", userID)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,51 @@
+package store
+
+import (
+	"context"
+	"time"
+)
+
+// ModerationEvent is a message or answer that matched the moderation thresholds.
+type ModerationEvent struct {
+	UserID     string
+	RoomID     string
+	EventID    string
+	Kind       string
+	Action     string
+	Categories string
+	CreatedAt  time.Time
+}
+
+// AddModerationEvent stores the moderation event.
+func (s *Store) AddModerationEvent(ctx context.Context, e ModerationEvent) error {
+	return s.exec(ctx,
+		`INSERT INTO moderation (user_id, room_id, event_id, kind, action, categories, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		e.UserID, e.RoomID, e.EventID, e.Kind, e.Action, e.Categories, e.CreatedAt.Unix(),
+	)
+}
+
+// GetModerationEvents returns up to limit moderation events, newest first.
+func (s *Store) GetModerationEvents(ctx context.Context, limit int) ([]ModerationEvent, error) {
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT user_id, room_id, event_id, kind, action, categories, created_at FROM moderation ORDER BY id DESC LIMIT $1",
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []ModerationEvent
+	for rows.Next() {
+		var e ModerationEvent
+		var createdAt int64
+		if err := rows.Scan(&e.UserID, &e.RoomID, &e.EventID, &e.Kind, &e.Action, &e.Categories, &createdAt); err != nil {
+			return nil, err
+		}
+		e.CreatedAt = time.Unix(createdAt, 0)
+		events = append(events, e)
+	}
+
+	return events, rows.Err()
+}
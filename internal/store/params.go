@@ -0,0 +1,26 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+// PutUserParams stores the serialized generation parameters of the user.
+func (s *Store) PutUserParams(ctx context.Context, userID string, params []byte) error {
+	return s.exec(ctx, "INSERT OR REPLACE INTO user_params (user_id, params) VALUES ($1, $2)", userID, params)
+}
+
+// GetUserParams returns the serialized generation parameters of the user, or nil if none are stored.
+func (s *Store) GetUserParams(ctx context.Context, userID string) ([]byte, error) {
+	var params []byte
+	err := s.db.QueryRowContext(ctx, "SELECT params FROM user_params WHERE user_id = $1", userID).Scan(&params)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return params, nil
+}
@@ -0,0 +1,87 @@
+package store
+
+import (
+	"context"
+	"time"
+)
+
+// Job is a scheduled prompt or reminder.
+type Job struct {
+	ID       int64
+	UserID   string
+	RoomID   string
+	ThreadID string
+	Kind     string
+	Spec     string
+	Message  string
+	NextRun  time.Time
+}
+
+// AddJob stores a new job and returns its ID.
+func (s *Store) AddJob(ctx context.Context, job Job) (int64, error) {
+	res, err := s.db.ExecContext(ctx,
+		"INSERT INTO schedule (user_id, room_id, thread_id, kind, spec, message, next_run) VALUES ($1, $2, $3, $4, $5, $6, $7)",
+		job.UserID, job.RoomID, job.ThreadID, job.Kind, job.Spec, job.Message, job.NextRun.Unix(),
+	)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// GetDueJobs returns the jobs whose next run is not after the given time.
+func (s *Store) GetDueJobs(ctx context.Context, now time.Time) ([]Job, error) {
+	return s.queryJobs(ctx, "WHERE next_run <= $1", now.Unix())
+}
+
+// GetUserJobs returns the jobs of the user in the room.
+func (s *Store) GetUserJobs(ctx context.Context, userID, roomID string) ([]Job, error) {
+	return s.queryJobs(ctx, "WHERE user_id = $1 AND room_id = $2", userID, roomID)
+}
+
+// CountUserJobs returns the number of jobs of the user in all rooms.
+func (s *Store) CountUserJobs(ctx context.Context, userID string) (int, error) {
+	var n int
+	err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM schedule WHERE user_id = $1", userID).Scan(&n)
+	return n, err
+}
+
+// UpdateJobNextRun sets the next run time of the job.
+func (s *Store) UpdateJobNextRun(ctx context.Context, id int64, next time.Time) error {
+	return s.exec(ctx, "UPDATE schedule SET next_run = $1 WHERE id = $2", next.Unix(), id)
+}
+
+// DeleteJob deletes the job of the user, reporting whether it existed.
+func (s *Store) DeleteJob(ctx context.Context, id int64, userID string) (bool, error) {
+	res, err := s.db.ExecContext(ctx, "DELETE FROM schedule WHERE id = $1 AND user_id = $2", id, userID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// queryJobs returns the jobs matching the where clause, ordered by their next run.
+func (s *Store) queryJobs(ctx context.Context, where string, args ...any) ([]Job, error) {
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT id, user_id, room_id, thread_id, kind, spec, message, next_run FROM schedule "+where+" ORDER BY next_run",
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var jobs []Job
+	for rows.Next() {
+		var j Job
+		var nextRun int64
+		if err := rows.Scan(&j.ID, &j.UserID, &j.RoomID, &j.ThreadID, &j.Kind, &j.Spec, &j.Message, &nextRun); err != nil {
+			return nil, err
+		}
+		j.NextRun = time.Unix(nextRun, 0)
+		jobs = append(jobs, j)
+	}
+
+	return jobs, rows.Err()
+}
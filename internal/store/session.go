@@ -0,0 +1,55 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+// Session holds the Matrix credentials of the bot account.
+type Session struct {
+	UserID      string
+	DeviceID    string
+	AccessToken string
+}
+
+// GetSession returns the stored session for the user, or nil if there is none.
+func (s *Store) GetSession(ctx context.Context, userID string) (*Session, error) {
+	sess := Session{UserID: userID}
+	err := s.db.QueryRowContext(ctx,
+		"SELECT device_id, access_token FROM session WHERE user_id=$1", userID,
+	).Scan(&sess.DeviceID, &sess.AccessToken)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &sess, nil
+}
+
+// PutSession stores the session, replacing any previous one for the same user.
+func (s *Store) PutSession(ctx context.Context, sess Session) error {
+	return s.exec(ctx,
+		"INSERT OR REPLACE INTO session (user_id, device_id, access_token) VALUES ($1, $2, $3)",
+		sess.UserID, sess.DeviceID, sess.AccessToken,
+	)
+}
+
+// GetCryptoDeviceID returns the device ID of the existing crypto account, or an empty string if there is none.
+// Logging in with the same device ID keeps the existing encryption keys valid.
+func (s *Store) GetCryptoDeviceID(ctx context.Context) (string, error) {
+	exists, err := s.db.TableExists(nil, "crypto_account")
+	if err != nil || !exists {
+		return "", err
+	}
+
+	var deviceID string
+	err = s.db.QueryRowContext(ctx, "SELECT device_id FROM crypto_account LIMIT 1").Scan(&deviceID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+
+	return deviceID, err
+}
@@ -0,0 +1,129 @@
+package store
+
+import (
+	"context"
+
+	"go.mau.fi/util/dbutil"
+)
+
+// Store is the persistent storage of the bot.
+// It shares the SQLite database with the Matrix crypto and state stores.
+type Store struct {
+	db *dbutil.Database
+}
+
+// migrations are executed in order on every start, so they must be idempotent.
+var migrations = []string{
+	`CREATE TABLE IF NOT EXISTS session (
+		user_id      TEXT PRIMARY KEY,
+		device_id    TEXT NOT NULL,
+		access_token TEXT NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS key_backup (
+		version    TEXT NOT NULL,
+		session_id TEXT NOT NULL,
+		PRIMARY KEY (version, session_id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS schedule (
+		id        INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id   TEXT NOT NULL,
+		room_id   TEXT NOT NULL,
+		thread_id TEXT NOT NULL DEFAULT '',
+		kind      TEXT NOT NULL,
+		spec      TEXT NOT NULL,
+		message   TEXT NOT NULL,
+		next_run  INTEGER NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS kb_chunk (
+		id        INTEGER PRIMARY KEY AUTOINCREMENT,
+		source    TEXT NOT NULL,
+		chunk     INTEGER NOT NULL,
+		content   TEXT NOT NULL,
+		embedding BLOB NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS kb_chunk_source_idx ON kb_chunk (source)`,
+	`CREATE TABLE IF NOT EXISTS memory (
+		id         INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id    TEXT NOT NULL,
+		fact       TEXT NOT NULL,
+		created_at INTEGER NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS memory_user_idx ON memory (user_id)`,
+	`CREATE TABLE IF NOT EXISTS conversation (
+		user_id    TEXT NOT NULL,
+		name       TEXT NOT NULL,
+		messages   BLOB NOT NULL,
+		updated_at INTEGER NOT NULL,
+		PRIMARY KEY (user_id, name)
+	)`,
+	`CREATE TABLE IF NOT EXISTS feedback (
+		user_id    TEXT NOT NULL,
+		room_id    TEXT NOT NULL,
+		event_id   TEXT NOT NULL,
+		rating     INTEGER NOT NULL,
+		prompt     TEXT NOT NULL,
+		answer     TEXT NOT NULL,
+		model      TEXT NOT NULL,
+		persona    TEXT NOT NULL,
+		created_at INTEGER NOT NULL,
+		PRIMARY KEY (user_id, event_id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS user_params (
+		user_id TEXT PRIMARY KEY,
+		params  BLOB NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS moderation (
+		id         INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id    TEXT NOT NULL,
+		room_id    TEXT NOT NULL,
+		event_id   TEXT NOT NULL,
+		kind       TEXT NOT NULL,
+		action     TEXT NOT NULL,
+		categories TEXT NOT NULL,
+		created_at INTEGER NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS answer (
+		event_id   TEXT PRIMARY KEY,
+		user_id    TEXT NOT NULL,
+		room_id    TEXT NOT NULL,
+		prompt     TEXT NOT NULL,
+		answer     TEXT NOT NULL,
+		model      TEXT NOT NULL,
+		persona    TEXT NOT NULL,
+		created_at INTEGER NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS answer_created_at_idx ON answer (created_at)`,
+}
+
+// New opens the SQLite database at the given path and creates missing tables.
+func New(path string) (*Store, error) {
+	db, err := dbutil.NewWithDialect(path, "sqlite3")
+	if err != nil {
+		return nil, err
+	}
+
+	for _, m := range migrations {
+		if _, err := db.Exec(m); err != nil {
+			_ = db.Close()
+			return nil, err
+		}
+	}
+
+	return &Store{db: db}, nil
+}
+
+// DB returns the underlying database, used to share it with the crypto helper.
+func (s *Store) DB() *dbutil.Database {
+	return s.db
+}
+
+// Close closes the database.
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// exec executes a query without returning any rows.
+func (s *Store) exec(ctx context.Context, query string, args ...any) error {
+	_, err := s.db.ExecContext(ctx, query, args...)
+	return err
+}